@@ -0,0 +1,133 @@
+package npad
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+//
+// PRIVATE PASTES [mtls client identity access list]
+//
+
+const _aclMax = 32 // max access list entries
+
+var (
+	errDenied  = errors.New("paste access denied")
+	errACL     = errors.New("invalid access list")
+	errACLmtls = errors.New("private pastes need mtls [CAclient]")
+)
+
+// pasteACL private paste access list, any match grants access [stored encrypted with the paste metadata]
+type pasteACL struct {
+	Fingerprints []string `json:"f,omitempty"` // client certificate sha256 fingerprints [hex]
+	CNs          []string `json:"c,omitempty"` // client certificate subject common names
+	Emails       []string `json:"e,omitempty"` // client certificate san email addresses
+}
+
+// parseACL parses the access spec [me|cn:<name>|email:<addr>|sha256:<hex>|<hex>] [separator: comma|newline]
+func (c *Config) parseACL(spec string, q *http.Request) (*pasteACL, error) {
+	var entries []string
+	for _, e := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if e = strings.TrimSpace(e); e != _empty {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	if c.CAclient == _empty {
+		return nil, errACLmtls
+	}
+	if len(entries) > _aclMax {
+		return nil, fmt.Errorf("%w [max:%d entries]", errACL, _aclMax)
+	}
+	a := &pasteACL{}
+	for _, e := range entries {
+		if strings.EqualFold(e, "me") {
+			leaf := verifiedLeaf(q.TLS)
+			if leaf == nil {
+				return nil, fmt.Errorf("%w [me] [needs a client certificate]", errACL)
+			}
+			a.Fingerprints = append(a.Fingerprints, certFingerprint(leaf))
+			continue
+		}
+		typ, value, found := strings.Cut(e, ":")
+		if _, ok := hexFingerprint(e); !found || ok {
+			typ, value = "sha256", e // bare fingerprint [openssl x509 -fingerprint: colon separated]
+		}
+		switch strings.ToLower(typ) {
+		case "cn":
+			a.CNs = append(a.CNs, value)
+		case "email":
+			a.Emails = append(a.Emails, strings.ToLower(value))
+		case "sha256":
+			fp, ok := hexFingerprint(value)
+			if !ok {
+				return nil, fmt.Errorf("%w [%s] [no sha256 fingerprint]", errACL, e)
+			}
+			a.Fingerprints = append(a.Fingerprints, fp)
+		default:
+			return nil, fmt.Errorf("%w [%s] [allowed:me|cn:|email:|sha256:]", errACL, e)
+		}
+	}
+	return a, nil
+}
+
+// hexFingerprint normalized sha256 fingerprint [lower hex, colons removed]
+func hexFingerprint(s string) (string, bool) {
+	fp := strings.ToLower(strings.ReplaceAll(s, ":", _empty))
+	b, err := hex.DecodeString(fp)
+	return fp, err == nil && len(b) == sha256.Size
+}
+
+// permits reports access for the verified client certificate of the request
+func (a *pasteACL) permits(q *http.Request) bool {
+	if a == nil {
+		return true
+	}
+	leaf := verifiedLeaf(q.TLS)
+	if leaf == nil {
+		return false
+	}
+	if slices.Contains(a.Fingerprints, certFingerprint(leaf)) {
+		return true
+	}
+	if leaf.Subject.CommonName != _empty && slices.Contains(a.CNs, leaf.Subject.CommonName) {
+		return true
+	}
+	for _, email := range leaf.EmailAddresses {
+		if slices.Contains(a.Emails, strings.ToLower(email)) {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize enforces the paste access list, denied requests look like missing pastes
+func authorize(q *http.Request, m pasteMeta) error {
+	if !m.ACL.permits(q) {
+		return errDenied
+	}
+	return nil
+}
+
+// verifiedLeaf returns the verified client certificate [nil: none]
+func verifiedLeaf(s *tls.ConnectionState) *x509.Certificate {
+	if s == nil || len(s.VerifiedChains) == 0 || len(s.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return s.VerifiedChains[0][0]
+}
+
+// certFingerprint sha256 fingerprint [hex]
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
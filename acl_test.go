@@ -0,0 +1,93 @@
+package npad
+
+import (
+	"crypto/x509"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseACL(t *testing.T) {
+	leaf := testLeaf(t, "alice", "Alice@Example.org")
+	fp := certFingerprint(leaf)
+	colon := strings.ToUpper(fp[:2])
+	for i := 2; i < len(fp); i += 2 {
+		colon += ":" + strings.ToUpper(fp[i:i+2])
+	}
+	c := &Config{CAclient: "ca.pem"}
+	tests := []struct {
+		name    string
+		spec    string
+		leaf    *x509.Certificate
+		want    *pasteACL
+		wantErr error
+	}{
+		{name: "empty", spec: ""},
+		{name: "blank", spec: " ,\t\n"},
+		{name: "me", spec: "me", leaf: leaf, want: &pasteACL{Fingerprints: []string{fp}}},
+		{name: "me upper", spec: "ME", leaf: leaf, want: &pasteACL{Fingerprints: []string{fp}}},
+		{name: "me without cert", spec: "me", wantErr: errACL},
+		{name: "cn", spec: "cn:bob", want: &pasteACL{CNs: []string{"bob"}}},
+		{name: "email lowered", spec: "email:Bob@Example.org", want: &pasteACL{Emails: []string{"bob@example.org"}}},
+		{name: "bare hex", spec: strings.ToUpper(fp), want: &pasteACL{Fingerprints: []string{fp}}},
+		{name: "sha256 colon hex", spec: "sha256:" + colon, want: &pasteACL{Fingerprints: []string{fp}}},
+		{name: "colon hex", spec: colon, want: &pasteACL{Fingerprints: []string{fp}}},
+		{name: "cn with space", spec: "cn:Alice Smith", want: &pasteACL{CNs: []string{"Alice Smith"}}},
+		{name: "mixed separators", spec: " cn:a ,cn:b\n email:c@d\t,\tsha256:" + fp, want: &pasteACL{CNs: []string{"a", "b"}, Emails: []string{"c@d"}, Fingerprints: []string{fp}}},
+		{name: "short hex", spec: "sha256:abcd", wantErr: errACL},
+		{name: "no hex", spec: strings.Repeat("zz", 32), wantErr: errACL},
+		{name: "unknown type", spec: "uid:1000", wantErr: errACL},
+		{name: "too many", spec: strings.Repeat("cn:x,", _aclMax+1), wantErr: errACL},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := c.parseACL(tc.spec, testRequest(tc.leaf))
+			switch {
+			case tc.wantErr != nil:
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("want %v, got %v", tc.wantErr, err)
+				}
+				return
+			case err != nil:
+				t.Fatal(err)
+			}
+			if (got == nil) != (tc.want == nil) {
+				t.Fatalf("got %+v, want %+v", got, tc.want)
+			}
+			if got != nil && (strings.Join(got.Fingerprints, ",") != strings.Join(tc.want.Fingerprints, ",") ||
+				strings.Join(got.CNs, ",") != strings.Join(tc.want.CNs, ",") ||
+				strings.Join(got.Emails, ",") != strings.Join(tc.want.Emails, ",")) {
+				t.Fatalf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+
+	// private pastes need a client ca
+	if _, err := (&Config{}).parseACL("cn:bob", testRequest(nil)); !errors.Is(err, errACLmtls) {
+		t.Fatalf("want errACLmtls, got %v", err)
+	}
+}
+
+func TestACLPermits(t *testing.T) {
+	alice, bob := testLeaf(t, "alice", "Alice@Example.org"), testLeaf(t, "bob", _empty)
+	tests := []struct {
+		name string
+		acl  *pasteACL
+		leaf *x509.Certificate
+		want bool
+	}{
+		{"public", nil, nil, true},
+		{"public with cert", nil, alice, true},
+		{"private without cert", &pasteACL{CNs: []string{"alice"}}, nil, false},
+		{"cn", &pasteACL{CNs: []string{"alice"}}, alice, true},
+		{"cn other", &pasteACL{CNs: []string{"alice"}}, bob, false},
+		{"email case", &pasteACL{Emails: []string{"alice@example.org"}}, alice, true},
+		{"fingerprint", &pasteACL{Fingerprints: []string{certFingerprint(bob)}}, bob, true},
+		{"fingerprint other", &pasteACL{Fingerprints: []string{certFingerprint(bob)}}, alice, false},
+	}
+	for _, tc := range tests {
+		if got := tc.acl.permits(testRequest(tc.leaf)); got != tc.want {
+			t.Errorf("%s: permits = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -3,9 +3,11 @@ package npad
 
 // import
 import (
+	"context"
 	"time"
 
 	"paepcke.de/logsec"
+	"paepcke.de/npad/compress"
 )
 
 // Config global app configuration for examples values -> see APP folder
@@ -14,18 +16,47 @@ type Config struct {
 	// APP
 	App string // app name [required]
 	// NETWORK
-	ListenAddr string // server listen Address [name:port] [required]
+	ListenAddr string     // server listen Address [name:port] [disable: <empty>, embedded Handler only]
+	PublicURL  string     // public base url for all generated links [https://tools.example/npad] [default: derived from ListenAddr]
+	BasePath   string     // path prefix the handlers are mounted at [/npad] [default: PublicURL path]
+	Listeners  []Listener // additional listeners [tls|loopback|unix socket], bound before chroot, sharing the mux [disable: <empty>]
+	// PROXIES [client address|scheme reported by trusted proxies, used for rate limits, logs and diag]
+	ListenProxy    bool     // expect a proxy protocol [v1|v2] header on ListenAddr [haproxy send-proxy] [disable: false]
+	TrustedProxies []string // trusted proxy [ip|cidr|unix] for proxy protocol and X-Forwarded-For|Proto [disable: <empty>]
+	// OPTIONAL RAW TCP ["nc"] UPLOAD LISTENER [cat file | nc host port]
+	NcAddr    string        // raw tcp upload listen address [name:port] [disable: <empty>]
+	NcTLS     bool          // use the server tls|mtls config on the nc listener [openssl s_client] [disable: false]
+	NcExpire  int           // nc upload retention tier [0:20min|1:8h|2:14days|3:never]
+	NcMax     int           // nc upload size limit [bytes] [default: retention tier limit]
+	NcTimeout time.Duration // nc upload max connection time [default: 30s]
 	// TLS CERTIFICATES
-	CAcert        string // server cert path [disable:<emptu>]
-	CAkey         string // server key path [disable:<empty>]
-	CAclient      string // clientCA certificate [disable: <empty>]
-	CAPrivateOnly bool   // if true, enforce mtls mode-only [disable: false]
+	CAcert        string        // server cert path [disable:<emptu>]
+	CAkey         string        // server key path [disable:<empty>]
+	CAclient      string        // clientCA certificate [disable: <empty>]
+	CAPrivateOnly bool          // if true, enforce mtls mode-only [tls listeners and NcTLS only, no loopback|unix listeners] [disable: false]
+	CertReload    time.Duration // [cert|key|clientca] change poll interval, SIGHUP forces a reload [default: 1m] [files stay readable after chroot]
+	TLS           TLSProfile    // tls profile [TLSStrict|TLSModern|TLSHybridPQ] [default: TLSStrict]
+	HTTP2         bool          // offer http/2 via alpn [disable: false]
+	// CLIENT CERTIFICATE REVOCATION [files stay readable after chroot]
+	CRLfiles  []string      // client ca crl files [pem|der] [disable: <empty>]
+	CRLreload time.Duration // crl reload interval [default: 1h]
+	CRLstrict bool          // fail-closed, reject client certs without current crl or good ocsp response [disable: false]
+	OCSPdir   string        // local ocsp responder stand-in, pre-fetched der responses [<serial hex>.der] [disable: <empty>]
+	// ROLE BASED AUTHORIZATION [client certificate attributes -> roles]
+	Roles *RolePolicy // read, upload, permanent and admin roles by [issuer|ou|san|fingerprint] [disable: nil, everyone holds read, upload and permanent]
 	// DATA STORE BACKEND
 	Calgo     string        // compression algo  [GZIP] [extended:ZSTD, see io.go] ][disable <empty>]
 	Clevel    int           // compression level [GZIP:1-9] [ZSTD:1-19] [disable: 0]
-	Ealgo     string        // encryption algo [AESGCM|GCMSIV|X|CHACHA20POLY1305]
-	AutoGC    bool          // enables removal of expired pastes [required]
-	AutoGCInt time.Duration // config how often store gc is processed [required]
+	Ealgo     string        // encryption algo [AESGCM|GCMSIV] [disable: <empty>]
+	AutoGC    bool          // deprecated, the store gc always runs unless DisableGC
+	DisableGC bool          // disables the periodic removal of expired pastes [permanent store only] [default: false]
+	AutoGCInt time.Duration // config how often store gc is processed [default: 20m]
+	// TRANSPORT
+	Tpolicy compress.Policy // transport compression levels by page size [default: compress.DefaultPolicy]
+	// SECURITY RESPONSE HEADERS
+	Headers *HeaderPolicy // security response header policy [default: DefaultHeaderPolicy] [disable: &HeaderPolicy{}]
+	// PER CLIENT RATE LIMITS [client ip|verified mtls client certificate]
+	Rlimit *RateLimits // token bucket limits for create, read and diag requests [default: DefaultRateLimits] [disable: &RateLimits{}]
 	// OPTIONAL PERMANENT DATA STORE FILE SYSTEM BACKEND
 	// *** WARNING *** deactivated by default, if activated, stores pastes in <ChrootDir> instead of ram [map]!
 	// *** WARNING *** any change or [de]activation of [encrypt|compress] parameter needs a complete permanent store wipe!
@@ -38,8 +69,17 @@ type Config struct {
 	i intercom // internal process communication
 }
 
-// Start ...
+// Start runs a standalone server until the first listener fails [blocks]
 func (conf *Config) Start() {
-	c = conf
-	start()
+	s, err := New(conf)
+	if err != nil {
+		logsec.ShowErr("[fatal] " + err.Error())
+		return
+	}
+	s.sighup = true
+	if err = s.Run(context.Background()); err != nil {
+		e := ("[shutdown] [fatal] [server error] [" + err.Error() + "]") // no recover after priv drop & crash
+		logsec.LogErr <- e
+		logsec.ShowErr(e)
+	}
 }
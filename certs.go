@@ -0,0 +1,177 @@
+package npad
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"paepcke.de/logsec"
+)
+
+//
+// HOT RELOADABLE SERVER CERTIFICATE, KEY AND CLIENT CA
+//
+
+const _certReload = time.Minute
+
+// tlsStore serves the current [cert|key|clientca] to every handshake, the files are pinned before the priv drop,
+// symlinks must resolve inside the file directory
+type tlsStore struct {
+	cert  *pinnedFile
+	key   *pinnedFile
+	ca    *pinnedFile // [nil: no client ca]
+	base  *tls.Config // shared tls settings
+	mu    sync.RWMutex
+	conf  *tls.Config // base + current certificate and client ca
+	cas   []*x509.Certificate
+	mtime [3]time.Time
+}
+
+// newTLSStore pins and loads [cert|key|clientca]
+func (c *Config) newTLSStore(base *tls.Config) (*tlsStore, error) {
+	ts := &tlsStore{base: base}
+	var err error
+	if ts.cert, err = pinFile(c.CAcert); err != nil {
+		return nil, err
+	}
+	if ts.key, err = pinFile(c.CAkey); err != nil {
+		return nil, err
+	}
+	if c.CAclient != _empty {
+		if ts.ca, err = pinFile(c.CAclient); err != nil {
+			return nil, err
+		}
+	}
+	if _, err = ts.reload(true); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// reload re-reads all files if forced or any file changed, a failing reload keeps the current config
+func (ts *tlsStore) reload(force bool) (bool, error) {
+	var mtime [3]time.Time
+	for i, f := range []*pinnedFile{ts.cert, ts.key, ts.ca} {
+		if f == nil {
+			continue
+		}
+		t, err := f.modTime()
+		if err != nil {
+			return false, err
+		}
+		mtime[i] = t
+	}
+	ts.mu.RLock()
+	unchanged := mtime == ts.mtime
+	ts.mu.RUnlock()
+	if unchanged && !force {
+		return false, nil
+	}
+	certPEM, err := ts.cert.read()
+	if err != nil {
+		return false, err
+	}
+	keyPEM, err := ts.key.read()
+	if err != nil {
+		return false, err
+	}
+	key, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, err
+	}
+	pool := x509.NewCertPool()
+	var cas []*x509.Certificate
+	if ts.ca != nil {
+		caPEM, err := ts.ca.read()
+		if err != nil {
+			return false, err
+		}
+		if cas = parseCerts(caPEM); len(cas) == 0 {
+			return false, errors.New("no client ca certificate [" + ts.ca.path + "]")
+		}
+		for _, ca := range cas {
+			pool.AddCert(ca)
+		}
+	}
+	conf := ts.base.Clone()
+	conf.Certificates = []tls.Certificate{key}
+	conf.ClientCAs = pool
+	ts.mu.Lock()
+	ts.conf, ts.cas, ts.mtime = conf, cas, mtime
+	ts.mu.Unlock()
+	return true, nil
+}
+
+// configForClient tls GetConfigForClient hook
+func (ts *tlsStore) configForClient(_ *tls.ClientHelloInfo) (*tls.Config, error) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.conf, nil
+}
+
+// clientCAs ...
+func (ts *tlsStore) clientCAs() []*x509.Certificate {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.cas
+}
+
+// leaf current server certificate
+func (ts *tlsStore) leaf() *x509.Certificate {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.conf.Certificates[0].Leaf
+}
+
+// tlsAutoReload reloads [cert|key|clientca] on file change, SIGHUP forces a reload of all files and crls
+// [sighup: standalone server only, an embedding host process keeps its own signal handling]
+func (c *Config) tlsAutoReload(ctx context.Context, sighup bool) {
+	ts := c.i.tls
+	if ts == nil {
+		return
+	}
+	interval := c.CertReload
+	if interval <= 0 {
+		interval = _certReload
+	}
+	var hup chan os.Signal // nil: never ready
+	if sighup {
+		hup = make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		force := false
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			force = true
+			logsec.LogInfo <- "[tls] [reload] [SIGHUP]"
+		case <-tick.C:
+		}
+		ok, err := ts.reload(force)
+		switch {
+		case err != nil:
+			logsec.LogErr <- "[tls] [reload] [keep current certificates] [" + err.Error() + "]"
+		case ok:
+			logsec.LogInfo <- "[tls] [reload] [" + ts.leaf().Subject.CommonName + "] [valid until:" + ts.leaf().NotAfter.UTC().Format(time.RFC3339) + "]"
+		}
+		if rv := c.i.revoke; rv != nil && (ok || force) {
+			if ok {
+				rv.setCAs(ts.clientCAs())
+			}
+			if err := rv.reload(); err != nil {
+				logsec.LogErr <- "[revocation] [reload] " + err.Error()
+			}
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"os"
 	"time"
 
 	"paepcke.de/logsec"
@@ -12,19 +13,67 @@ func main() {
 		App: "npad", // name [required]
 		// NETWORK
 		ListenAddr: "paste.paepcke.pnoc:443", // server listen Address [name:port] required]
+		PublicURL:  "",                       // public base url for all generated links [https://tools.example/npad] [default: derived from ListenAddr]
+		BasePath:   "",                       // path prefix the handlers are mounted at [/npad] [default: PublicURL path]
+		Listeners:  nil,                      // additional listeners, bound before chroot, sharing the mux [disable: <empty>]
+		// Listeners examples:
+		// {Addr: "127.0.0.1:8080", Transport: npad.TransportLoopback},                       // plaintext tcp, loopback only [local reverse proxy]
+		// {Addr: "/var/run/npad.sock", Transport: npad.TransportUnix, Mode: 0o660, GID: 80}, // unix socket [mode] [group] [local reverse proxy]
+		// PROXIES [client address|scheme reported by trusted proxies, used for rate limits, logs and diag]
+		ListenProxy:    false, // expect a proxy protocol [v1|v2] header on ListenAddr [haproxy send-proxy] [disable: false]
+		TrustedProxies: nil,   // trusted proxy [ip|cidr|unix] for proxy protocol and X-Forwarded-For|Proto [127.0.0.1|unix] [disable: <empty>]
+		// OPTIONAL RAW TCP ["nc"] UPLOAD LISTENER [cat file | nc host port]
+		NcAddr:    "",               // raw tcp upload listen address [name:port] [disable: <empty>]
+		NcTLS:     true,             // use the server tls|mtls config on the nc listener [openssl s_client] [disable: false]
+		NcExpire:  1,                // nc upload retention tier [0:20min|1:8h|2:14days|3:never]
+		NcMax:     0,                // nc upload size limit [bytes] [default: retention tier limit]
+		NcTimeout: 30 * time.Second, // nc upload max connection time [default: 30s]
 		// TLS CERTIFICATES
 		CAcert:        "/etc/app/npad/paste.pem", // server cert path [required]
 		CAkey:         "/etc/app/npad/paste.key", // server key path [required]
 		CAclient:      "/etc/ssl/clientCA.pem",   // clientCA certificate [disable: <empty>]
 		CAPrivateOnly: false,                     // if true, enforce mtls mode-only [disable: false]
+		CertReload:    1 * time.Minute,           // [cert|key|clientca] change poll interval, SIGHUP forces a reload [default: 1m] [files stay readable after chroot]
+		TLS:           npad.TLSStrict,            // tls profile [TLSStrict|TLSModern|TLSHybridPQ] [default: TLSStrict]
+		HTTP2:         false,                     // offer http/2 via alpn [disable: false]
+		// CLIENT CERTIFICATE REVOCATION [files stay readable after chroot]
+		CRLfiles:  nil,           // client ca crl files [pem|der] [/etc/ssl/clientCA.crl] [disable: <empty>]
+		CRLreload: 1 * time.Hour, // crl reload interval [default: 1h]
+		CRLstrict: false,         // fail-closed, reject client certs without current crl or good ocsp response [disable: false]
+		OCSPdir:   "",            // local ocsp responder stand-in, pre-fetched der responses [<serial hex>.der] [disable: <empty>]
+		// ROLE BASED AUTHORIZATION [client certificate attributes -> roles]
+		Roles: nil, // role policy [disable: nil, everyone holds read, upload and permanent, no admin]
+		// Roles example:
+		// &npad.RolePolicy{
+		//	Rules: []npad.RoleRule{
+		//		{OU: "ops", Roles: npad.RoleRead | npad.RoleUpload | npad.RolePermanent}, // subject organizational unit
+		//		{SAN: "admin@example.org", Roles: npad.RoleAdmin},                        // san [email|dns|uri]
+		//	},
+		//	Default:   npad.RoleRead | npad.RoleUpload, // verified clients without matching rule
+		//	Anonymous: npad.RoleRead,                   // clients without verified certificate
+		// },
 		// DATA STORE BACKEND any change or [de]activation of [compres|encrypt] parameter need a store wipe!
 		Calgo:  "ZSTD", // compression algo  [GZIP|ZSTD] [disable <empty>]
 		Clevel: 6,      // compression level [GZIP:1-9|ZSTD:1-19] [disable: 0]
-		Ealgo:  "",     // encryption algo [AESGCM|GCMSIV|X|CHACHA20POLY1205] [disable: <empty>]
+		Ealgo:  "",     // encryption algo [AESGCM|GCMSIV] [disable: <empty>]
+		// TRANSPORT
+		Tpolicy: nil, // transport compression levels by page size [default: nil: copy of compress.DefaultPolicy]
+		// SECURITY RESPONSE HEADERS
+		Headers: nil, // security response header policy [default: nil: copy of npad.DefaultHeaderPolicy] [disable: &npad.HeaderPolicy{}]
+		// PER CLIENT RATE LIMITS [client ip|verified mtls client certificate]
+		Rlimit: &npad.RateLimits{
+			Create: npad.Rate{PerSec: 0.2, Burst: 10}, // uploads [form|raw|api|nc] [disable: 0]
+			Read:   npad.Rate{PerSec: 5, Burst: 50},   // paste views, downloads, qr, api read|delete [disable: 0]
+			Diag:   npad.Rate{PerSec: 0.5, Burst: 5},  // connection diagnosis [disable: 0]
+			Idle:   10 * time.Minute,                  // evict idle client buckets after [default: 10m]
+		},
 		// OPTIONAL PERMANENT DATA STORE FILE SYSTEM BACKEND
 		// *** WARNING *** deactivated by default, if activated, stores pastes in <ChrootDir> instead of ram [map]!
 		// *** WARNING *** any change or [de]activation of [encrypt|compress] parameter needs a complete permanent store wipe!
 		PermSTORE: true, // activeate the filesystem backed permanent store [disable: false]
+		// STORE GC
+		DisableGC: false,            // periodic removal of expired pastes [disable: true, permanent store only]
+		AutoGCInt: 20 * time.Minute, // store gc interval [default: 20m]
 		// Log
 		Log: &logsec.LogD{
 			App:            "npad",                 // app log entries name
@@ -39,5 +88,14 @@ func main() {
 			GID: 2004,         // chroot user GID number [disable: 0]
 		},
 	}
+	// npad preflight: check the compiled-in config on the deployment target [no bind, no chroot]
+	if len(os.Args) > 1 && os.Args[1] == "preflight" {
+		report, ok := c.Preflight()
+		os.Stdout.WriteString(report)
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
 	c.Start()
 }
@@ -8,11 +8,11 @@ import (
 	"compress/gzip"
 	"compress/zlib"
 	"errors"
-	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/klauspost/compress/zstd"
 )
@@ -21,6 +21,34 @@ import (
 const (
 	_dot   = "."
 	_empty = ""
+	_mtu   = 1400 // skip compression attempt if data fits uncompressed into one TCP frame [MTU]
+)
+
+// Policy maps page size classes to transport compression levels, first matching class wins
+type Policy []Level
+
+// Level transport compression levels for pages up to Size bytes
+type Level struct {
+	Size int // upper page size bound [bytes] [0: unbound]
+	ZSTD int // zstd level [1-19] [0: skip]
+	GZIP int // gzip|deflate level [1-9] [0: skip]
+}
+
+// DefaultPolicy cheap levels for big pages, transport compression must not dominate cpu time per request
+var DefaultPolicy = Policy{
+	{Size: 64 * 1024, ZSTD: 6, GZIP: 6},
+	{Size: 1024 * 1024, ZSTD: 3, GZIP: 4},
+	{Size: 0, ZSTD: 1, GZIP: 1},
+}
+
+// encoder|decoder pools [per level]
+var (
+	zstdEnc [20]sync.Pool
+	gzipEnc [10]sync.Pool
+	zlibEnc [10]sync.Pool
+	zstdDec sync.Pool
+	gzipDec sync.Pool
+	zlibDec sync.Pool
 )
 
 //
@@ -152,33 +180,230 @@ func GetFileScanner(name string) (s *bufio.Scanner, err error) {
 	return bufio.NewScanner(r), nil
 }
 
-// WriteTransportCompressedPage ...
-func WriteTransportCompressedPage(page string, r http.ResponseWriter, q *http.Request, tryCompress bool) {
-	p := []byte(page)
+// Level returns the transport compression levels for the page size
+func (p Policy) Level(size int) Level {
+	for _, l := range p {
+		if l.Size == 0 || size <= l.Size {
+			return l
+		}
+	}
+	return Level{}
+}
+
+// GetTransportEncoding returns the http content-encoding token for a compression algo [<empty>: none]
+func GetTransportEncoding(algo string) string {
+	switch algo {
+	case "ZSTD":
+		return "zstd"
+	case "GZIP":
+		return "gzip"
+	case "DEFLATE":
+		return "deflate"
+	}
+	return _empty
+}
+
+// Accepts reports if the client accepts the content-encoding [explicit token q wins over *, q=0 refuses]
+func Accepts(q *http.Request, enc string) bool {
+	if enc == _empty {
+		return false
+	}
+	explicit, wildcard := -1, -1 // [-1: not listed, 0: refused, 1: accepted]
+	for _, h := range q.Header["Accept-Encoding"] {
+		for _, e := range strings.Split(h, ",") {
+			token, params, _ := strings.Cut(e, ";")
+			accept := 1
+			if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok && strings.Trim(v, "0.") == _empty {
+				accept = 0
+			}
+			switch token = strings.TrimSpace(token); {
+			case strings.EqualFold(token, enc) && explicit < 0:
+				explicit = accept
+			case token == "*" && wildcard < 0:
+				wildcard = accept
+			}
+		}
+	}
+	if explicit < 0 {
+		return wildcard == 1
+	}
+	return explicit == 1
+}
+
+// WriteEncodedPage writes already [stored] compressed data as-is, the client decodes via content-encoding
+func WriteEncodedPage(in io.Reader, enc string, r http.ResponseWriter) {
+	r.Header().Add("Vary", "Accept-Encoding")
+	r.Header().Set("Content-Encoding", enc)
+	if _, err := io.Copy(r, in); err != nil {
+		errOut("[handler] [out] [" + err.Error() + "]")
+	}
+}
+
+// NewTransportWriter negotiates the transport compression [zstd|gzip|deflate] for a page of about size
+// bytes and returns the response body writer, Close flushes the stream and recycles the pooled encoder
+func NewTransportWriter(r http.ResponseWriter, q *http.Request, size int, policy Policy) io.WriteCloser {
+	if size <= _mtu {
+		return nopCloser{r}
+	}
+	l := policy.Level(size)
+	r.Header().Add("Vary", "Accept-Encoding")
+	algo, level := _empty, 0
+	switch {
+	case Accepts(q, "zstd") && l.ZSTD > 0:
+		algo, level = "ZSTD", l.ZSTD
+	case Accepts(q, "gzip") && l.GZIP > 0:
+		algo, level = "GZIP", l.GZIP
+	case Accepts(q, "deflate") && l.GZIP > 0:
+		algo, level = "DEFLATE", l.GZIP
+	default:
+		return nopCloser{r}
+	}
+	w, err := NewWriter(algo, level, r)
+	if err != nil {
+		errOut(err.Error())
+		return nopCloser{r}
+	}
+	r.Header().Set("Content-Encoding", GetTransportEncoding(algo))
+	return w
+}
+
+// NewWriter returns a pooled streaming compressor, Close flushes the stream and recycles the encoder
+func NewWriter(algo string, level int, w io.Writer) (io.WriteCloser, error) {
+	switch algo {
+	case "ZSTD":
+		level = clamp(level, 19)
+		z, err := getZstd(level)
+		if err != nil {
+			return nil, errors.New("unable to create new zstd writer [" + err.Error() + "]")
+		}
+		z.Reset(w)
+		return &pooled{Writer: z, done: func() error {
+			err := z.Close()
+			zstdEnc[level].Put(z)
+			return err
+		}}, nil
+	case "GZIP":
+		level = clamp(level, 9)
+		z, err := getGzip(level, w)
+		if err != nil {
+			return nil, errors.New("unable to create new gzip writer [" + err.Error() + "]")
+		}
+		return &pooled{Writer: z, done: func() error {
+			err := z.Close()
+			gzipEnc[level].Put(z)
+			return err
+		}}, nil
+	case "DEFLATE":
+		level = clamp(level, 9)
+		z, err := getZlib(level, w)
+		if err != nil {
+			return nil, errors.New("unable to create new deflate writer [" + err.Error() + "]")
+		}
+		return &pooled{Writer: z, done: func() error {
+			err := z.Close()
+			zlibEnc[level].Put(z)
+			return err
+		}}, nil
+	}
+	return nil, errors.New("unsupported compression algo [requested:" + algo + "]")
+}
+
+// NewReader returns a pooled streaming decompressor, Close recycles the decoder
+func NewReader(algo string, r io.Reader) (io.ReadCloser, error) {
 	var err error
-	if tryCompress && len(page) > 1400 { // skip compression attempt if data fits uncompressed into one TCP frame [MTU]
-		// compression auto-negoation optimized for size  [zstd|br|gzip]
-		accept := strings.Join(q.Header["Accept-Encoding"], " ")
-		switch {
-		case strings.Contains(accept, "zstd"):
-			r.Header().Set("Content-Encoding", "zstd")
-			p = Compress("ZSTD", 19, p)
-		case strings.Contains(accept, "gzip"):
-			r.Header().Set("Content-Encoding", "gzip")
-			p = Compress("GZIP", 9, p)
-		case strings.Contains(accept, "deflate"):
-			r.Header().Set("Content-Encoding", "deflate")
-			p = Compress("DEFLATE", 9, p)
+	switch algo {
+	case "ZSTD":
+		d, ok := zstdDec.Get().(*zstd.Decoder)
+		switch ok {
+		case true:
+			err = d.Reset(r)
+		default:
+			d, err = zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		}
+		if err != nil {
+			return nil, errors.New("unable to create new de-compress reader [" + algo + "] [" + err.Error() + "]")
+		}
+		return &pooled{Reader: d, done: func() error {
+			_ = d.Reset(nil) // drop the source reader before pooling
+			zstdDec.Put(d)
+			return nil
+		}}, nil
+	case "GZIP":
+		d, ok := gzipDec.Get().(*gzip.Reader)
+		switch ok {
+		case true:
+			err = d.Reset(r)
+		default:
+			d, err = gzip.NewReader(r)
 		}
-		_, err = fmt.Fprint(r, string(p))
-	} else {
-		_, err = fmt.Fprint(r, page)
+		if err != nil {
+			return nil, errors.New("unable to create new de-compress reader [" + algo + "] [" + err.Error() + "]")
+		}
+		return &pooled{Reader: d, done: func() error {
+			_ = d.Reset(bytes.NewReader(nil)) // drop the source reader before pooling [nil panics]
+			gzipDec.Put(d)
+			return nil
+		}}, nil
+	case "DEFLATE":
+		d, ok := zlibDec.Get().(io.ReadCloser)
+		switch ok {
+		case true:
+			err = d.(zlib.Resetter).Reset(r, nil)
+		default:
+			d, err = zlib.NewReader(r)
+		}
+		if err != nil {
+			return nil, errors.New("unable to create new de-compress reader [" + algo + "] [" + err.Error() + "]")
+		}
+		return &pooled{Reader: d, done: func() error {
+			_ = d.(zlib.Resetter).Reset(bytes.NewReader(nil), nil) // drop the source reader before pooling [nil panics]
+			zlibDec.Put(d)
+			return nil
+		}}, nil
+	}
+	return nil, errors.New("unsupported de-compress algo [" + algo + "]")
+}
+
+// WriteTransportCompressedPage ...
+func WriteTransportCompressedPage(page string, r http.ResponseWriter, q *http.Request, tryCompress bool, policy Policy) {
+	size := len(page)
+	if !tryCompress {
+		size = 0
+	}
+	w := NewTransportWriter(r, q, size, policy)
+	_, err := io.WriteString(w, page)
+	if errc := w.Close(); err == nil {
+		err = errc
 	}
 	if err != nil {
 		errOut("[handler] [out] [" + err.Error() + "]")
 	}
 }
 
+// pooled [de]compressing stream around a pooled [en|de]coder
+type pooled struct {
+	io.Writer
+	io.Reader
+	done func() error
+}
+
+// Close flushes and recycles the [en|de]coder, safe to call more than once
+func (p *pooled) Close() error {
+	if p.done == nil {
+		return nil
+	}
+	err := p.done()
+	p.done = nil
+	p.Writer, p.Reader = nil, nil
+	return err
+}
+
+// nopCloser uncompressed response body writer
+type nopCloser struct{ io.Writer }
+
+// Close ...
+func (nopCloser) Close() error { return nil }
+
 //
 // INTERNAL BACKENDS: NATIVE GO
 //
@@ -187,24 +412,29 @@ func decompressGO(algo string, data []byte) []byte {
 	if algo == "" {
 		return data
 	}
-	var err error
-	var r io.Reader
-	br := bytes.NewReader(data)
-	switch algo {
-	case "ZSTD":
-		r, err = zstd.NewReader(br)
-	case "GZIP":
-		r, err = gzip.NewReader(br)
-	case "DEFLATE":
-		r, err = zlib.NewReader(br)
-	default:
-		errOut("unsupported de-compress algo [" + algo + "]")
-		return nil
+	if algo == "ZSTD" {
+		d, ok := zstdDec.Get().(*zstd.Decoder)
+		if !ok {
+			var err error
+			if d, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1)); err != nil {
+				errOut("unable to create new de-compress reader [" + algo + "]")
+				return nil
+			}
+		}
+		out, err := d.DecodeAll(data, nil)
+		zstdDec.Put(d)
+		if err != nil {
+			errOut("[decompress] [" + algo + "] [" + err.Error() + "]")
+			return nil
+		}
+		return out
 	}
+	r, err := NewReader(algo, bytes.NewReader(data))
 	if err != nil {
-		errOut("unable to create new de-compress reader [" + algo + "]")
+		errOut(err.Error())
 		return nil
 	}
+	defer r.Close()
 	out, err := io.ReadAll(r)
 	if err != nil {
 		errOut("[decompress] [" + algo + "] [" + err.Error() + "]")
@@ -217,66 +447,66 @@ func compressGO(algo string, level int, data []byte) []byte {
 	if algo == "" || level == 0 {
 		return data
 	}
-	var buf bytes.Buffer
-	switch algo {
-	case "ZSTD":
-		if level > 19 {
-			level = 19
-		}
-		w, err := zstd.NewWriter(nil,
-			zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
-			zstd.WithEncoderCRC(false),
-			zstd.WithZeroFrames(false),
-			zstd.WithSingleSegment(true),
-			zstd.WithLowerEncoderMem(false),
-			zstd.WithAllLitEntropyCompression(true),
-			zstd.WithNoEntropyCompression(false))
+	if algo == "ZSTD" {
+		level = clamp(level, 19)
+		w, err := getZstd(level)
 		if err != nil {
 			errOut("unable to create new zstd writer [" + err.Error() + "]")
 			return nil
 		}
-		out := w.EncodeAll(data, nil)
-		w.Close()
+		out := w.EncodeAll(data, make([]byte, 0, len(data)/2))
+		zstdEnc[level].Put(w)
 		return out
-	case "GZIP":
-		if level > 9 {
-			level = 9
-		}
-		w, err := gzip.NewWriterLevel(&buf, level)
-		if err != nil {
-			errOut("unable to create new gzip writer [" + err.Error() + "]")
-			return nil
-		}
-		if _, err = w.Write(data); err != nil {
-			errOut("unable to write via gzip writer [" + err.Error() + "]")
-			return nil
-		}
-		w.Close()
-	case "DEFLATE":
-		if level > 9 {
-			level = 9
-		}
-		w, err := zlib.NewWriterLevel(&buf, level)
-		if err != nil {
-			errOut("unable to create new deflate writer [" + err.Error() + "]")
-			return nil
-		}
-		if _, err = w.Write(data); err != nil {
-			errOut("unable to write via deflate writer [" + err.Error() + "]")
-			return nil
-		}
-		w.Close()
-	default:
-		errOut("unsupported compression algo [requested:" + algo + "]")
-		return nil
 	}
-	r := io.Reader(&buf)
-	out, err := io.ReadAll(r)
+	var buf bytes.Buffer
+	w, err := NewWriter(algo, level, &buf)
 	if err != nil {
+		errOut(err.Error())
+		return nil
+	}
+	if _, err = w.Write(data); err != nil {
+		errOut("unable to write via [" + algo + "] writer [" + err.Error() + "]")
+		return nil
+	}
+	if err = w.Close(); err != nil {
 		errOut("[compress] [algo:" + algo + "] [" + err.Error() + "]")
 		return nil
 	}
-	return out
+	return buf.Bytes()
+}
+
+// getZstd returns a pooled zstd encoder [level: 1-19]
+func getZstd(level int) (*zstd.Encoder, error) {
+	if w, ok := zstdEnc[level].Get().(*zstd.Encoder); ok {
+		return w, nil
+	}
+	return zstd.NewWriter(nil,
+		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+		zstd.WithEncoderConcurrency(1),
+		zstd.WithEncoderCRC(false),
+		zstd.WithZeroFrames(false),
+		zstd.WithSingleSegment(true), // EncodeAll only, no effect on streams
+		zstd.WithLowerEncoderMem(false),
+		zstd.WithAllLitEntropyCompression(true),
+		zstd.WithNoEntropyCompression(false))
+}
+
+// getGzip returns a pooled gzip writer targeting w [level: 1-9]
+func getGzip(level int, w io.Writer) (*gzip.Writer, error) {
+	if z, ok := gzipEnc[level].Get().(*gzip.Writer); ok {
+		z.Reset(w)
+		return z, nil
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+// getZlib returns a pooled deflate [zlib] writer targeting w [level: 1-9]
+func getZlib(level int, w io.Writer) (*zlib.Writer, error) {
+	if z, ok := zlibEnc[level].Get().(*zlib.Writer); ok {
+		z.Reset(w)
+		return z, nil
+	}
+	return zlib.NewWriterLevel(w, level)
 }
 
 //
@@ -291,6 +521,17 @@ func compressGO(algo string, level int, data []byte) []byte {
 // LITTLE HELPER
 //
 
+// clamp level into [1-max]
+func clamp(level, top int) int {
+	switch {
+	case level < 1:
+		return 1
+	case level > top:
+		return top
+	}
+	return level
+}
+
 // out ...
 func out(in string) { os.Stdout.Write([]byte(in)) }
 
@@ -0,0 +1,73 @@
+package compress
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAccepts(t *testing.T) {
+	tests := []struct {
+		accept []string
+		enc    string
+		want   bool
+	}{
+		{nil, "zstd", false},
+		{[]string{"zstd"}, "zstd", true},
+		{[]string{"gzip, deflate, br, zstd"}, "zstd", true},
+		{[]string{"gzip, deflate"}, "zstd", false},
+		{[]string{"ZSTD"}, "zstd", true},
+		{[]string{"gzip;q=1.0"}, "gzip", true},
+		{[]string{"gzip;q=0.5"}, "gzip", true},
+		{[]string{"gzip;q=0.001"}, "gzip", true},
+		{[]string{"gzip;q=0"}, "gzip", false},
+		{[]string{"gzip;q=0.0"}, "gzip", false},
+		{[]string{"gzip;q=0.000"}, "gzip", false},
+		{[]string{"gzip ; q=0"}, "gzip", false},
+		{[]string{"zstd;q=0, gzip"}, "gzip", true},
+		{[]string{"zstd;q=0, gzip"}, "zstd", false},
+		{[]string{"deflate", "zstd"}, "zstd", true},
+		{[]string{"*"}, "zstd", true},
+		{[]string{"*;q=0"}, "gzip", false},
+		{[]string{"gzip, *;q=0"}, "gzip", true},
+		{[]string{"gzip, *;q=0"}, "zstd", false},
+		{[]string{"zstd;q=0, *"}, "zstd", false},
+		{[]string{"zstd;q=0, *"}, "gzip", true},
+		{[]string{"*", "zstd;q=0"}, "zstd", false},
+		{[]string{"identity"}, "", false},
+		{[]string{"zstd"}, "", false},
+	}
+	for _, tc := range tests {
+		q := httptest.NewRequest("GET", "/", nil)
+		for _, a := range tc.accept {
+			q.Header.Add("Accept-Encoding", a)
+		}
+		if got := Accepts(q, tc.enc); got != tc.want {
+			t.Errorf("Accepts(%q, %q) = %v, want %v", tc.accept, tc.enc, got, tc.want)
+		}
+	}
+}
+
+func TestReaderReuse(t *testing.T) {
+	for _, algo := range []string{"ZSTD", "GZIP", "DEFLATE"} {
+		for i, want := range []string{"first stream", "second stream"} {
+			var buf bytes.Buffer
+			w, err := NewWriter(algo, 3, &buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(w, want)
+			w.Close()
+			r, err := NewReader(algo, &buf)
+			if err != nil {
+				t.Fatalf("%s [%d] %v", algo, i, err)
+			}
+			got, err := io.ReadAll(r)
+			r.Close()
+			if err != nil || string(got) != want {
+				t.Fatalf("%s [%d] got %q, %v", algo, i, got, err)
+			}
+		}
+	}
+}
@@ -2,29 +2,44 @@ package npad
 
 import (
 	"crypto/tls"
-	"crypto/x509"
+	"errors"
+	"maps"
 	"net"
+	"net/netip"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 
 	"paepcke.de/logsec"
+	"paepcke.de/npad/compress"
 )
 
-// global config
-var c *Config
-
 // internal
 type intercom struct {
 	// INTERNAL: INTERCOM
 	store      map[string][]byte
+	fs         *os.Root // fs store directory [permanent store]
 	storeMUTEX sync.RWMutex
 	// INTERNAL: KEYS AND STATES
 	magicOFFSET    int
 	plainOFFSET    int
 	downloadOFFSET int
 	qrOFFSET       int
+	apiOFFSET      int
 	storeZERO      bool
+	storeENC       string   // stored paste content-encoding token [<empty>: uncompressed store]
+	etags          sync.Map // stored paste strong etag cache [store key -> etag]
+	headers        []header // pre-computed security response headers
+	hsts           string   // pre-computed Strict-Transport-Security value [tls only]
+	rlCreate       *limiter // per client rate limits [nil: unlimited]
+	rlRead         *limiter
+	rlDiag         *limiter
+	csrfKey        []byte         // upload form token hmac key [per process]
+	revoke         *revocation    // client certificate revocation state [nil: disabled]
+	tls            *tlsStore      // hot reloadable [cert|key|clientca] [nil: plaintext mode]
+	proxies        []netip.Prefix // trusted proxies [proxy protocol|X-Forwarded-For]
+	proxyUnix      bool           // trust unix socket peers
 	// INTRNAL: PRE COMPUTED WEB ELEMENTS
 	head1  string
 	head2  string
@@ -32,22 +47,41 @@ type intercom struct {
 	head3b string
 	home   string
 	banner string
-	url    string
+	url    string // public base url [no trailing slash]
+	base   string // mount path prefix [<empty>: /]
+	origin string // public origin [scheme://host]
 }
 
-func configure() {
+func (c *Config) configure() {
 	// init ram store backend [map]
 	if !c.PermSTORE {
 		c.i.store = make(map[string][]byte)
 	}
 	// pre-compute backend parameter
-	if (c.Calgo == "GZIP" && c.Clevel > 9) || (c.Calgo == "ZSTD" && c.Clevel > 19) {
-		panic("invalid compression level [" + c.Calgo + "] [" + itoa(c.Clevel) + "]")
+	// defaults are copied, servers never share or alter the package level policies
+	if c.Tpolicy == nil {
+		c.Tpolicy = slices.Clone(compress.DefaultPolicy)
+	}
+	if c.Headers == nil {
+		h := DefaultHeaderPolicy
+		h.Extra = maps.Clone(h.Extra)
+		c.Headers = &h
+	}
+	c.i.headers, c.i.hsts = c.Headers.headerList()
+	if c.Rlimit == nil {
+		r := DefaultRateLimits
+		c.Rlimit = &r
+	}
+	c.i.csrfKey = genRand()[:32]
+	c.i.rlCreate, c.i.rlRead, c.i.rlDiag = newLimiter(c.Rlimit.Create), newLimiter(c.Rlimit.Read), newLimiter(c.Rlimit.Diag)
+	if c.Clevel > 0 {
+		c.i.storeENC = compress.GetTransportEncoding(c.Calgo)
 	}
 	c.i.downloadOFFSET = len(_download)
 	c.i.plainOFFSET = len(_plain)
 	c.i.magicOFFSET = len(_magic)
 	c.i.qrOFFSET = len(_qr)
+	c.i.apiOFFSET = len(_api)
 	e := c.Ealgo
 	if c.Ealgo == "" {
 		e = "DISABLED"
@@ -60,12 +94,15 @@ func configure() {
 	ss := s
 	if c.PermSTORE {
 		s = "PERSISTENT:FS"
-		ss = c.Chroot.DIR
+		ss = c.i.fs.Name()
 	}
 	n := "PLAINTEXT"
 	proto := "http://"
 	if c.CAcert != "" && c.CAkey != "" {
-		n = "TLS13"
+		n = "TLS13:" + strings.ToUpper(c.TLS.String())
+		if c.HTTP2 {
+			n += ":H2"
+		}
 		proto = "https://"
 	}
 	if c.CAclient != "" {
@@ -74,70 +111,95 @@ func configure() {
 	if c.CAPrivateOnly {
 		n += ":ONLY"
 	}
-	c.i.url = proto + c.ListenAddr
+	derived := proto + c.ListenAddr
 	x := strings.Split(c.ListenAddr, ":")
 	if len(x) == 2 {
 		if x[1] == "80" && proto == "http://" {
-			c.i.url = proto + x[0]
+			derived = proto + x[0]
 		}
 		if x[1] == "443" && proto == "https://" {
-			c.i.url = proto + x[0]
+			derived = proto + x[0]
 		}
 	}
+	c.i.url, c.i.base, c.i.origin = c.publicBase(derived)
 	// pre-compute ux components
-	c.i.home = href + c.i.url + "\">" + "<button>" + home + " " + c.i.url + bue + "</a> "
-	c.i.home += href + "/diag\">" + "<button>" + diag + bue + "</a> "
-	c.i.home += href + "/src\">" + "<button>" + git + bue + "</a><br>"
-	c.i.head1 = h1 + "\n\t<title>\n\t" + c.App + "\n\t</title>" + endHead
-	c.i.head2 = h2 + "\n\t<title>\n\t" + c.App + "\n\t</title>" + endHead
-	c.i.head3 = h2 + "\n\t<title>\n\t" + c.App + "\n\t</title>" + syntax_css + endHead
-	c.i.head3b = h2b + "\n\t<title>\n\t" + c.App + "\n\t</title>" + syntax_css + endHead
+	c.i.home = href + c.i.url + "/\">" + "<button>" + home + " " + c.i.url + bue + "</a> "
+	c.i.home += href + c.i.base + _diag + "\">" + "<button>" + diag + bue + "</a> "
+	c.i.home += href + c.i.base + _src + "\">" + "<button>" + git + bue + "</a><br>"
+	favicon := icon + c.i.base + _favicon + iconEnd
+	c.i.head1 = h1 + favicon + "\n\t<title>\n\t" + c.App + "\n\t</title>" + endHead
+	c.i.head2 = h2 + favicon + "\n\t<title>\n\t" + c.App + "\n\t</title>" + endHead
+	c.i.head3 = h2 + favicon + "\n\t<title>\n\t" + c.App + "\n\t</title>" + syntax_css + endHead
+	c.i.head3b = h2b + favicon + "\n\t<title>\n\t" + c.App + "\n\t</title>" + syntax_css + endHead
 	c.i.banner = c.i.home
 	c.i.banner += bu + transport + " TRANSPORT:" + n + bue
 	c.i.banner += bu + store + " STORE:" + s + ":" + o + bue
 	c.i.banner += bu + lock + " ENCRYPT:" + e + bue + "<br>"
 	// report configuration stats
-	logsec.LogInfo <- "[" + c.ListenAddr + "] [TRANSPORT:" + n + "] [LOG:" + c.Log.LogMode + "]"
+	logsec.LogInfo <- "[" + c.ListenAddr + "] [URL:" + c.i.url + "] [TRANSPORT:" + n + "] [LOG:" + c.Log.LogMode + "]"
 	logsec.LogInfo <- "[STORE:" + ss + "] [STORE:COMPRESS:" + o + "] [STORE:ENCRYPT:" + e + "]"
 }
 
-func getTLSConf() (listen net.Listener, err error) {
-	if c.CAcert != "" && c.CAkey != "" {
-
-		key, err := tls.LoadX509KeyPair(c.CAcert, c.CAkey)
-		if err != nil {
-			logsec.ShowErr("unable to [read|decode] [cert|key] [" + c.CAcert + "|" + c.CAkey + "] [" + err.Error() + "]")
-			return listen, err
-		}
+// listen binds addr, proxy protocol if proxy is set, tls|mtls if tlsConf is set
+func (c *Config) listen(addr string, tlsConf *tls.Config, proxy bool) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return c.wrapListener(l, tlsConf, proxy)
+}
 
-		caClient := x509.NewCertPool()
-		clientAuthMode := tls.VerifyClientCertIfGiven
-		if c.CAclient != "" {
-			cert, err := os.ReadFile(c.CAclient)
-			if err != nil {
-				logsec.ShowErr("unable to [read] clientCA cert [" + c.CAclient + "] [" + err.Error() + "]")
-				return listen, err
-			}
-			caClient.AppendCertsFromPEM(cert)
-			clientAuthMode = tls.VerifyClientCertIfGiven
-			if c.CAPrivateOnly {
-				clientAuthMode = tls.RequireAndVerifyClientCert
-			}
+// wrapListener adds the proxy protocol [outer] and tls [inner] layer
+func (c *Config) wrapListener(l net.Listener, tlsConf *tls.Config, proxy bool) (net.Listener, error) {
+	if proxy {
+		if len(c.i.proxies) == 0 && !c.i.proxyUnix {
+			l.Close()
+			return nil, errors.New("proxy protocol needs trusted proxies [TrustedProxies]")
 		}
+		l = &proxyListener{Listener: l, c: c}
+	}
+	if tlsConf != nil {
+		l = tls.NewListener(l, tlsConf)
+	}
+	return l, nil
+}
 
-		tlsConf := &tls.Config{
-			Certificates:           []tls.Certificate{key},
-			ClientCAs:              caClient,
-			ClientAuth:             clientAuthMode,
-			MinVersion:             tls.VersionTLS13,
-			MaxVersion:             tls.VersionTLS13,
-			CipherSuites:           []uint16{tls.TLS_CHACHA20_POLY1305_SHA256},
-			CurvePreferences:       []tls.CurveID{tls.X25519},
-			NextProtos:             []string{"http/1.1"},
-			SessionTicketsDisabled: true,
-			Renegotiation:          0,
-		}
-		return tls.Listen("tcp", c.ListenAddr, tlsConf)
+// getTLSConfig returns the shared server tls|mtls config [nil: plaintext mode]
+func (c *Config) getTLSConfig() (*tls.Config, error) {
+	if c.CAcert == "" || c.CAkey == "" {
+		return nil, nil
+	}
+	curves, err := c.TLS.curves()
+	if err != nil {
+		logsec.ShowErr(err.Error())
+		return nil, err
+	}
+	clientAuthMode := tls.VerifyClientCertIfGiven
+	if c.CAclient != "" && c.CAPrivateOnly {
+		clientAuthMode = tls.RequireAndVerifyClientCert
+	}
+	ts, err := c.newTLSStore(&tls.Config{
+		ClientAuth:             clientAuthMode,
+		MinVersion:             tls.VersionTLS13,
+		MaxVersion:             tls.VersionTLS13,
+		CurvePreferences:       curves,
+		NextProtos:             c.nextProtos(),
+		SessionTicketsDisabled: true,
+		Renegotiation:          0,
+		VerifyPeerCertificate:  c.verifyRevocation,
+	})
+	if err != nil {
+		logsec.ShowErr("unable to [read|decode] [cert|key|clientca] [" + c.CAcert + "|" + c.CAkey + "|" + c.CAclient + "] [" + err.Error() + "]")
+		return nil, err
+	}
+	rv, err := c.newRevocation(ts.clientCAs())
+	if err != nil {
+		logsec.ShowErr("unable to load client certificate revocation lists [" + err.Error() + "]")
+		return nil, err
 	}
-	return net.Listen("tcp", c.ListenAddr)
+	c.i.tls, c.i.revoke = ts, rv
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS13,
+		GetConfigForClient: ts.configForClient,
+	}, nil
 }
@@ -1,7 +1,14 @@
 package npad
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
 	"net/http"
+	"os"
+	"sync"
+	"time"
 
 	"paepcke.de/logsec"
 )
@@ -15,54 +22,196 @@ const (
 	_src      = "/src/"
 	_favicon  = "/i.svg"
 	_download = "/download/"
+	_api      = "/api/v1/pastes"
 	_empty    = ""
 	_linefeed = "\n"
 	_space    = " "
+
+	_shutdownTimeout = 10 * time.Second // graceful shutdown on Run cancellation
 )
 
-// start ...
-func start() {
-	// start logging
-	logsec.LogDaemon(c.Log)
+// Server embeddable npad instance
+type Server struct {
+	c       *Config
+	tls     *tls.Config // shared server tls|mtls config [nil: plaintext mode]
+	handler http.Handler
+	sighup  bool // SIGHUP forces a tls reload [standalone Start only]
+}
+
+// logDaemon logsec is process wide, the first server starts it
+var logDaemon sync.Once
+
+// New validates cfg, loads the tls config, keys and store [before any priv drop], cfg must not be shared between servers
+func New(cfg *Config) (*Server, error) {
+	c := cfg
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	logDaemon.Do(func() { logsec.LogDaemon(c.Log) })
+	tlsConf, err := c.getTLSConfig()
+	if err != nil {
+		return nil, errors.New("unable to load tls config [" + err.Error() + "]")
+	}
+	if err = c.parseTrustedProxies(); err != nil {
+		return nil, err
+	}
+	if c.PermSTORE {
+		dir := "."
+		if c.Chroot != nil && c.Chroot.DIR != _empty {
+			dir = c.Chroot.DIR
+		}
+		if c.i.fs, err = os.OpenRoot(dir); err != nil {
+			return nil, errors.New("unable to open store directory [" + err.Error() + "]")
+		}
+	}
+
+	// setup keys, store, ux elements
+	c.configure()
+	return &Server{c: c, tls: tlsConf, handler: c.newHandler()}, nil
+}
+
+// Handler returns the complete npad handler [mount it at BasePath, run the background maintenance via Maintain]
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// newHandler ...
+func (c *Config) newHandler() http.Handler {
+	// setup mux
+	mux := http.NewServeMux()
+
+	// handler [per client rate limits|role based authorization|csrf origin checks|per route body limits|concurrency cap on expensive routes]
+	heavy := heavySlots()
+	mux.Handle(_root, c.rateLimit(false, c.requireRole(c.csrfGuard(limitBody(_maxUpload+_frame, c.getStartHandler())))))
+	mux.Handle(_download, c.rateLimit(false, c.requireRole(limitBody(0, c.getDownloadHandler()))))
+	mux.Handle(_qr, c.rateLimit(false, c.requireRole(limitBody(0, limitConcurrency(heavy, c.getQRHandler())))))
+	mux.Handle(_plain, c.rateLimit(false, c.requireRole(limitBody(0, c.getPlainHandler()))))
+	mux.Handle(_magic, c.rateLimit(false, c.requireRole(limitBody(0, limitConcurrency(heavy, c.getMagicHandler())))))
+	mux.Handle(_diag, c.rateLimit(true, c.requireRole(limitBody(0, c.getDiagHandler()))))
+	mux.Handle(_src, c.requireRole(getSourceCodeHandler()))
+	mux.Handle(_favicon, c.requireRole(c.getFavIconHandler()))
+	mux.Handle(_api, c.rateLimit(false, c.requireRole(c.csrfGuard(limitBody(_apiMaxBody, c.getAPIHandler())))))
+	mux.Handle(_api+"/", c.rateLimit(false, c.requireRole(c.csrfGuard(limitBody(_apiMaxBody, c.getAPIHandler())))))
+	return c.forwarded(c.secureHeaders(c.mount(mux)))
+}
+
+// Run binds the listeners [ListenAddr|Listeners|NcAddr], drops privs [Chroot], runs the background maintenance
+// and serves until ctx is done, fails without any listener [embedded Handler: use Maintain]
+func (s *Server) Run(ctx context.Context) error {
+	c := s.c
+	if c.ListenAddr == _empty && len(c.Listeners) == 0 && c.NcAddr == _empty {
+		return errors.New("no listener [ListenAddr|Listeners|NcAddr] [embedded handler: use Maintain]")
+	}
 
 	// bind ports before priv drop
-	listener, err := getTLSConf()
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+	if c.ListenAddr != _empty {
+		listener, err := c.listen(c.ListenAddr, s.tls, c.ListenProxy)
+		if err != nil {
+			return errors.New("unable to bind to address [" + c.ListenAddr + "] [" + err.Error() + "]")
+		}
+		listeners = append(listeners, listener)
+	}
+	extra, err := c.listenAll(s.tls)
 	if err != nil {
-		logsec.ShowErr("[fatal] unable to bind to address [" + c.ListenAddr + "] [" + err.Error() + "]")
-		return
+		closeAll()
+		return errors.New("unable to bind listener " + err.Error())
+	}
+	listeners = append(listeners, extra...)
+	var ncListener net.Listener
+	if c.NcAddr != "" {
+		ncTLS := s.tls
+		if !c.NcTLS {
+			ncTLS = nil
+		}
+		if ncListener, err = c.listen(c.NcAddr, ncTLS, false); err != nil {
+			closeAll()
+			return errors.New("unable to bind to nc address [" + c.NcAddr + "] [" + err.Error() + "]")
+		}
+		defer ncListener.Close()
 	}
 
 	// drop privs
-	if logsec.Chroot(c.Chroot) {
-
-		// setup mux
-		mux := http.NewServeMux()
-
-		// handler
-		mux.Handle(_root, getStartHandler())
-		mux.Handle(_download, getDownloadHandler())
-		mux.Handle(_qr, getQRHandler())
-		mux.Handle(_plain, getPlainHandler())
-		mux.Handle(_magic, getMagicHandler())
-		mux.Handle(_diag, getDiagHandler())
-		mux.Handle(_src, getSourceCodeHandler())
-		mux.Handle(_favicon, getFavIconHandler())
-
-		//
-		httpsrv := &http.Server{
-			Handler: mux,
-		}
+	if c.Chroot != nil && !logsec.Chroot(c.Chroot) {
+		closeAll()
+		return errors.New("unable to drop privileges [chroot]")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.maintain(ctx)
+
+	// raw tcp upload listener
+	if ncListener != nil {
+		go c.serveNc(ncListener)
+	}
+
+	//
+	httpsrv := &http.Server{
+		Handler:           s.handler,
+		ConnContext:       proxyConnContext,
+		ReadHeaderTimeout: _readHeaderTimeout,
+		ReadTimeout:       _readTimeout,
+		WriteTimeout:      _writeTimeout,
+		IdleTimeout:       _idleTimeout,
+		MaxHeaderBytes:    _maxHeaderBytes,
+	}
+
+	// serve requestes [all listeners share the mux, the first failing listener ends the server]
+	errc := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() { errc <- httpsrv.Serve(l) }()
+	}
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		sctx, scancel := context.WithTimeout(context.Background(), _shutdownTimeout)
+		defer scancel()
+		logsec.LogInfo <- "[shutdown] [" + context.Cause(ctx).Error() + "]"
+		return httpsrv.Shutdown(sctx)
+	}
+	httpsrv.Close()
+	return err
+}
 
-		// store gc
-		go storeAutoGC()
+// Maintain runs the background maintenance [store gc, rate limit gc, crl|tls reload] of an embedded Handler
+// until ctx is done, no listener, no priv drop
+func (s *Server) Maintain(ctx context.Context) error {
+	s.maintain(ctx)
+	<-ctx.Done()
+	return nil
+}
 
-		// setup keys, store, ux elements
-		configure()
+// maintain starts the background maintenance, ends with ctx
+func (s *Server) maintain(ctx context.Context) {
+	c := s.c
+
+	// store gc
+	go c.storeAutoGC(ctx)
+
+	// rate limit bucket gc
+	go c.rateAutoGC(ctx)
+
+	// client certificate revocation list reload
+	go c.revocationAutoReload(ctx)
+
+	// server certificate, key and client ca reload
+	go c.tlsAutoReload(ctx, s.sighup)
+}
 
-		// serve requestes
-		err = httpsrv.Serve(listener)
-		e := ("[shutdown] [fatal] [server error] [" + err.Error() + "]") // no recover after priv drop & crash
-		logsec.LogErr <- e
-		logsec.ShowErr(e)
+// sleep waits d, false if ctx is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
 	}
 }
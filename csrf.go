@@ -0,0 +1,103 @@
+package npad
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"paepcke.de/logsec"
+)
+
+//
+// CSRF PROTECTION [origin checks|stateless hmac form token]
+//
+
+const (
+	_csrfField = "ct"            // upload form token field name
+	_csrfTTL   = 12 * time.Hour  // max upload form age
+	_csrfSkew  = 1 * time.Minute // tolerated clock skew
+	_csrfMAC   = 16              // truncated hmac size [bytes]
+	_csrfTS    = 8               // token timestamp size [bytes]
+	_csrfLen   = _csrfTS + _csrfMAC
+)
+
+// csrfGuard rejects state changing browser requests [POST|PUT|DELETE] from foreign origins,
+// non-browser clients [curl|api] send neither Sec-Fetch-Site nor Origin and pass
+func (c *Config) csrfGuard(next http.Handler) http.Handler {
+	h := func(r http.ResponseWriter, q *http.Request) {
+		switch q.Method {
+		case http.MethodPost, http.MethodPut, http.MethodDelete:
+			if reason := c.foreignOrigin(q); reason != _empty {
+				logsec.LogErr <- "[csrf] [" + q.Method + "] [" + reason + "]"
+				if strings.HasPrefix(q.URL.Path, _api) {
+					apiFail(r, http.StatusForbidden, "forbidden", "cross-origin request ["+reason+"]")
+					return
+				}
+				forbidden(r, "cross-origin request ["+reason+"]")
+				return
+			}
+		}
+		next.ServeHTTP(r, q)
+	}
+	return http.HandlerFunc(h)
+}
+
+// foreignOrigin returns the rejection reason of a cross origin request [<empty>: same origin|no browser]
+func (c *Config) foreignOrigin(q *http.Request) string {
+	switch site := q.Header.Get("Sec-Fetch-Site"); site {
+	case _empty, "same-origin", "none":
+	default:
+		return "sec-fetch-site:" + site
+	}
+	origin := q.Header.Get("Origin")
+	if origin == _empty {
+		return _empty
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == _empty {
+		return "origin:" + origin
+	}
+	if !strings.EqualFold(u.Host, q.Host) && !strings.EqualFold(origin, c.i.origin) {
+		return "origin:" + origin
+	}
+	return _empty
+}
+
+// csrfToken returns a stateless upload form token [timestamp|hmac(timestamp|client certificate)]
+func (c *Config) csrfToken(q *http.Request) string {
+	var t [_csrfLen]byte
+	binary.BigEndian.PutUint64(t[:_csrfTS], uint64(time.Now().Unix()))
+	copy(t[_csrfTS:], c.csrfMAC(t[:_csrfTS], q))
+	return base64.RawURLEncoding.EncodeToString(t[:])
+}
+
+// csrfValid verifies form token signature, client binding and age
+func (c *Config) csrfValid(q *http.Request, token string) bool {
+	t, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(t) != _csrfLen {
+		return false
+	}
+	if !hmac.Equal(t[_csrfTS:], c.csrfMAC(t[:_csrfTS], q)) {
+		return false
+	}
+	age := time.Since(time.Unix(int64(binary.BigEndian.Uint64(t[:_csrfTS])), 0))
+	return age > -_csrfSkew && age < _csrfTTL
+}
+
+// csrfMAC binds the token to the verified mtls client certificate, if any
+func (c *Config) csrfMAC(ts []byte, q *http.Request) []byte {
+	m := hmac.New(sha256.New, c.i.csrfKey)
+	m.Write(ts)
+	m.Write([]byte(certID(q.TLS)))
+	return m.Sum(nil)[:_csrfMAC]
+}
+
+// forbidden ...
+func forbidden(r http.ResponseWriter, reason string) {
+	http.Error(r, "Error: Forbidden (403) ["+reason+"]", http.StatusForbidden)
+}
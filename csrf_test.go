@@ -0,0 +1,50 @@
+package npad
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestCSRFToken(t *testing.T) {
+	c := &Config{}
+	c.i.csrfKey = genRand()[:32]
+	alice, bob := testLeaf(t, "alice", _empty), testLeaf(t, "bob", _empty)
+	// token issued at ts for the client certificate leaf [nil: none]
+	token := func(ts time.Time, leaf *x509.Certificate) string {
+		var b [_csrfLen]byte
+		binary.BigEndian.PutUint64(b[:_csrfTS], uint64(ts.Unix()))
+		copy(b[_csrfTS:], c.csrfMAC(b[:_csrfTS], testRequest(leaf)))
+		return base64.RawURLEncoding.EncodeToString(b[:])
+	}
+	foreign := &Config{}
+	foreign.i.csrfKey = genRand()[:32]
+	now := time.Now()
+	tests := []struct {
+		name  string
+		token string
+		leaf  *x509.Certificate
+		want  bool
+	}{
+		{"fresh", c.csrfToken(testRequest(nil)), nil, true},
+		{"fresh client cert", c.csrfToken(testRequest(alice)), alice, true},
+		{"almost expired", token(now.Add(-_csrfTTL+time.Minute), nil), nil, true},
+		{"expired", token(now.Add(-_csrfTTL-time.Second), nil), nil, false},
+		{"within clock skew", token(now.Add(_csrfSkew/2), nil), nil, true},
+		{"future", token(now.Add(2*_csrfSkew), nil), nil, false},
+		{"other client cert", token(now, alice), bob, false},
+		{"cert token without cert", token(now, alice), nil, false},
+		{"plain token with cert", token(now, nil), alice, false},
+		{"other key", foreign.csrfToken(testRequest(nil)), nil, false},
+		{"empty", _empty, nil, false},
+		{"truncated", token(now, nil)[:20], nil, false},
+		{"no base64", "!!!!", nil, false},
+	}
+	for _, tc := range tests {
+		if got := c.csrfValid(testRequest(tc.leaf), tc.token); got != tc.want {
+			t.Errorf("%s: csrfValid = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
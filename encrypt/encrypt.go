@@ -17,23 +17,7 @@ func Encrypt(algo string, key [32]byte, iv, data []byte) ([]byte, error) {
 	if key == [32]byte{} || len(iv) < 16 {
 		return []byte{}, errors.New("[enc] [active:" + algo + "] [but no valid keys]")
 	}
-	var err error
-	var x cipher.AEAD
-	switch algo {
-	case "GCMSIV":
-		x, err = gcmsiv.NewGCMSIV(key[:])
-	case "AESGCM":
-		e, err := aes.NewCipher(key[:])
-		if err != nil {
-			return []byte{}, err
-		}
-		x, err = cipher.NewGCM(e)
-		if err != nil {
-			return []byte{}, err
-		}
-	default:
-		panic("unsupported encryption algo [" + algo + "]")
-	}
+	x, err := newAEAD(algo, key)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -45,25 +29,33 @@ func Decrypt(algo string, key [32]byte, iv, data []byte) ([]byte, error) {
 	if key == [32]byte{} || len(iv) < 16 {
 		return []byte{}, errors.New("[enc] [active:" + algo + "] [but no valid keys]")
 	}
-	var err error
-	var x cipher.AEAD
+	x, err := newAEAD(algo, key)
+	if err != nil {
+		return []byte{}, err
+	}
+	return x.Open(nil, iv[:x.NonceSize()], data, nil)
+}
+
+// Supported reports if algo is a supported encryption algo
+func Supported(algo string) bool {
+	switch algo {
+	case "GCMSIV", "AESGCM":
+		return true
+	}
+	return false
+}
+
+// newAEAD ...
+func newAEAD(algo string, key [32]byte) (cipher.AEAD, error) {
 	switch algo {
 	case "GCMSIV":
-		x, err = gcmsiv.NewGCMSIV(key[:])
+		return gcmsiv.NewGCMSIV(key[:])
 	case "AESGCM":
-		d, err := aes.NewCipher(key[:])
-		if err != nil {
-			return []byte{}, err
-		}
-		x, err = cipher.NewGCM(d)
+		e, err := aes.NewCipher(key[:])
 		if err != nil {
-			return []byte{}, err
+			return nil, err
 		}
-	default:
-		panic("unsupported encryption algo [" + algo + "]")
-	}
-	if err != nil {
-		return []byte{}, err
+		return cipher.NewGCM(e)
 	}
-	return x.Open(nil, iv[:x.NonceSize()], data, nil)
+	return nil, errors.New("[enc] [unsupported encryption algo] [" + algo + "]")
 }
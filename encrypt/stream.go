@@ -0,0 +1,231 @@
+package encrypt
+
+// import
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+)
+
+//
+// STREAM [online authenticated encryption]
+//
+// Ciphertext: stream header [_header] || sealed chunks, pastes without header are legacy single shot sealed.
+// The plaintext is split into ChunkSize chunks, every chunk is sealed on its own.
+// Chunk nonce: iv prefix [nonce size - 5] || chunk counter [uint32 big endian] || final chunk flag [0|1]
+// Reordered, dropped or truncated chunks fail authentication, random access needs
+// only the chunks covering the requested range.
+//
+
+// ChunkSize plaintext bytes per sealed chunk
+const ChunkSize = 64 * 1024
+
+// const
+const (
+	_tag    = 16
+	_block  = ChunkSize + _tag
+	_header = "NPADSTR1" // stream format magic, version 1
+)
+
+// ErrNoStream ciphertext without stream header [legacy single shot sealed]
+var ErrNoStream = errors.New("[enc] [stream] [no stream header]")
+
+var errStream = errors.New("[enc] [stream] [invalid ciphertext size]")
+
+// NewStreamWriter returns a chunked encrypting writer, Close seals the final chunk
+func NewStreamWriter(algo string, key [32]byte, iv []byte, w io.Writer) (io.WriteCloser, error) {
+	x, err := newStream(algo, key, iv)
+	if err != nil {
+		return nil, err
+	}
+	return &streamWriter{stream: x, w: w, buf: make([]byte, 0, ChunkSize)}, nil
+}
+
+// StreamReader decrypts a chunked ciphertext with random access [io.ReaderAt] to the plaintext
+type StreamReader struct {
+	stream
+	r      io.ReaderAt
+	size   int64 // plaintext size
+	chunks int64 // total chunks
+	mu     sync.Mutex
+	idx    int64  // cached chunk index
+	plain  []byte // cached chunk plaintext
+	raw    []byte
+}
+
+// NewStreamReader returns the plaintext view of size bytes ciphertext, the first chunk is authenticated upfront,
+// ErrNoStream without stream header
+func NewStreamReader(algo string, key [32]byte, iv []byte, r io.ReaderAt, size int64) (*StreamReader, error) {
+	if size < int64(len(_header)) {
+		return nil, ErrNoStream
+	}
+	head := make([]byte, len(_header))
+	if _, err := r.ReadAt(head, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if string(head) != _header {
+		return nil, ErrNoStream
+	}
+	x, err := newStream(algo, key, iv)
+	if err != nil {
+		return nil, err
+	}
+	size -= int64(len(_header))
+	r = io.NewSectionReader(r, int64(len(_header)), size)
+	chunks := (size + _block - 1) / _block
+	if size < _tag || size-(chunks-1)*_block < _tag {
+		return nil, errStream
+	}
+	s := &StreamReader{
+		stream: x,
+		r:      r,
+		size:   size - chunks*_tag,
+		chunks: chunks,
+		idx:    -1,
+		raw:    make([]byte, _block),
+	}
+	if _, err := s.chunk(0); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Size returns the plaintext size
+func (s *StreamReader) Size() int64 { return s.size }
+
+// ReadAt ...
+func (s *StreamReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("[enc] [stream] [negative offset]")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for n < len(p) {
+		if off >= s.size {
+			return n, io.EOF
+		}
+		plain, err := s.chunk(off / ChunkSize)
+		if err != nil {
+			return n, err
+		}
+		m := copy(p[n:], plain[off%ChunkSize:])
+		n += m
+		off += int64(m)
+	}
+	return n, nil
+}
+
+// chunk returns the authenticated plaintext of chunk i, caller holds mu [or owns s exclusively]
+func (s *StreamReader) chunk(i int64) ([]byte, error) {
+	if i == s.idx {
+		return s.plain, nil
+	}
+	raw := s.raw[:_block]
+	n, err := s.r.ReadAt(raw, i*_block)
+	if err != nil && !(err == io.EOF && i == s.chunks-1) {
+		return nil, err
+	}
+	plain, err := s.aead.Open(s.plain[:0], s.nonce(uint32(i), i == s.chunks-1), raw[:n], nil)
+	if err != nil {
+		s.idx = -1
+		return nil, errors.New("[enc] [stream] [chunk:" + strconv.FormatInt(i, 10) + "] [" + err.Error() + "]")
+	}
+	s.idx, s.plain = i, plain
+	return plain, nil
+}
+
+// streamWriter ...
+type streamWriter struct {
+	stream
+	w      io.Writer
+	buf    []byte
+	out    []byte
+	ctr    uint32
+	closed bool
+}
+
+// Write ...
+func (s *streamWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errors.New("[enc] [stream] [write after close]")
+	}
+	n := 0
+	for len(p) > 0 {
+		if len(s.buf) == ChunkSize { // more data follows, the buffered chunk is not the final one
+			if err := s.seal(false); err != nil {
+				return n, err
+			}
+		}
+		m := copy(s.buf[len(s.buf):ChunkSize], p)
+		s.buf = s.buf[:len(s.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+// Close seals the final chunk, does not close the underlying writer
+func (s *streamWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.seal(true)
+}
+
+// seal ...
+func (s *streamWriter) seal(final bool) error {
+	if s.ctr == ^uint32(0) {
+		return errors.New("[enc] [stream] [max chunks exceeded]")
+	}
+	s.out = s.out[:0]
+	if s.ctr == 0 {
+		s.out = append(s.out, _header...)
+	}
+	s.out = s.aead.Seal(s.out, s.nonce(s.ctr, final), s.buf, nil)
+	s.buf = s.buf[:0]
+	s.ctr++
+	_, err := s.w.Write(s.out)
+	return err
+}
+
+// stream shared aead and nonce state
+type stream struct {
+	aead cipher.AEAD
+	n    []byte // nonce: iv prefix || counter || flag
+}
+
+// newStream ...
+func newStream(algo string, key [32]byte, iv []byte) (stream, error) {
+	var x stream
+	if key == [32]byte{} || len(iv) < 16 {
+		return x, errors.New("[enc] [active:" + algo + "] [but no valid keys]")
+	}
+	aead, err := newAEAD(algo, key)
+	if err != nil {
+		return x, err
+	}
+	l := aead.NonceSize()
+	if l < 12 || l > len(iv)+5 || aead.Overhead() != _tag {
+		return x, errors.New("[enc] [stream] [unsupported aead nonce|tag size] [" + algo + "]")
+	}
+	x.aead = aead
+	x.n = make([]byte, l)
+	copy(x.n, iv[:l-5])
+	return x, nil
+}
+
+// nonce ...
+func (s *stream) nonce(ctr uint32, final bool) []byte {
+	l := len(s.n)
+	binary.BigEndian.PutUint32(s.n[l-5:l-1], ctr)
+	s.n[l-1] = 0
+	if final {
+		s.n[l-1] = 1
+	}
+	return s.n
+}
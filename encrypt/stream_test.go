@@ -0,0 +1,119 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+// seal ...
+func seal(t *testing.T, algo string, key [32]byte, iv, plain []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w, err := NewStreamWriter(algo, key, iv, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// open ...
+func open(algo string, key [32]byte, iv, data []byte) ([]byte, error) {
+	r, err := NewStreamReader(algo, key, iv, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(io.NewSectionReader(r, 0, r.Size()))
+}
+
+func TestStream(t *testing.T) {
+	var key [32]byte
+	iv := make([]byte, 16)
+	rand.Read(key[:])
+	rand.Read(iv)
+	tests := []struct {
+		name    string
+		size    int
+		tamper  func([]byte) []byte
+		wantErr error // nil: round trip, ErrNoStream: exact, other: any error
+	}{
+		{name: "empty", size: 0},
+		{name: "one byte", size: 1},
+		{name: "chunk minus one", size: ChunkSize - 1},
+		{name: "exact chunk", size: ChunkSize},
+		{name: "exact multiple", size: 3 * ChunkSize},
+		{name: "multiple plus one", size: 2*ChunkSize + 1},
+		{name: "truncated final chunk", size: 2*ChunkSize + 100, tamper: func(b []byte) []byte { return b[:len(b)-1] }, wantErr: errStream},
+		{name: "dropped final chunk", size: 2 * ChunkSize, tamper: func(b []byte) []byte { return b[:len(b)-_block] }, wantErr: errStream},
+		{name: "empty truncated", size: 0, tamper: func(b []byte) []byte { return b[:len(_header)] }, wantErr: errStream},
+		{name: "tampered first chunk", size: ChunkSize + 1, tamper: func(b []byte) []byte { b[len(_header)] ^= 1; return b }, wantErr: errStream},
+		{name: "tampered middle chunk", size: 3 * ChunkSize, tamper: func(b []byte) []byte { b[len(_header)+_block+7] ^= 1; return b }, wantErr: errStream},
+		{name: "no header", size: 10, tamper: func(b []byte) []byte { return b[len(_header):] }, wantErr: ErrNoStream},
+		{name: "short input", size: 0, tamper: func(b []byte) []byte { return b[:3] }, wantErr: ErrNoStream},
+	}
+	for _, algo := range []string{"AESGCM", "GCMSIV"} {
+		for _, tc := range tests {
+			t.Run(algo+"/"+tc.name, func(t *testing.T) {
+				plain := make([]byte, tc.size)
+				rand.Read(plain)
+				data := seal(t, algo, key, iv, plain)
+				if tc.tamper != nil {
+					data = tc.tamper(data)
+				}
+				got, err := open(algo, key, iv, data)
+				switch {
+				case tc.wantErr == nil && err != nil:
+					t.Fatalf("unexpected error: %v", err)
+				case tc.wantErr == nil && !bytes.Equal(got, plain):
+					t.Fatalf("plaintext mismatch [got %d bytes, want %d]", len(got), len(plain))
+				case tc.wantErr == ErrNoStream && !errors.Is(err, ErrNoStream):
+					t.Fatalf("want ErrNoStream, got %v", err)
+				case tc.wantErr != nil && err == nil:
+					t.Fatal("tampered ciphertext accepted")
+				}
+			})
+		}
+	}
+}
+
+func TestStreamReadAt(t *testing.T) {
+	var key [32]byte
+	iv := make([]byte, 16)
+	plain := make([]byte, 3*ChunkSize+17)
+	rand.Read(key[:])
+	rand.Read(iv)
+	rand.Read(plain)
+	data := seal(t, "AESGCM", key, iv, plain)
+	r, err := NewStreamReader("AESGCM", key, iv, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		off, n int
+	}{
+		{0, 10},
+		{ChunkSize - 5, 10},
+		{2*ChunkSize + 3, ChunkSize},
+		{len(plain) - 17, 17},
+	}
+	for _, tc := range tests {
+		p := make([]byte, tc.n)
+		if _, err := r.ReadAt(p, int64(tc.off)); err != nil && err != io.EOF {
+			t.Fatalf("[off:%d] %v", tc.off, err)
+		}
+		if !bytes.Equal(p, plain[tc.off:tc.off+tc.n]) {
+			t.Fatalf("[off:%d] mismatch", tc.off)
+		}
+	}
+	if _, err := r.ReadAt(make([]byte, 1), int64(len(plain))); err != io.EOF {
+		t.Fatalf("read past end: %v", err)
+	}
+}
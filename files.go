@@ -0,0 +1,111 @@
+package npad
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//
+// PINNED FILES [readable after chroot]
+//
+
+// pinnedFile keeps a file outside the chroot readable, the directory handle and the file descriptor are opened
+// before the priv drop, atomic replacements [rename] of the file stay visible as long as the dropped user can
+// read them, files only readable before the priv drop [root owned key, mode 0600] are re-read via the descriptor
+type pinnedFile struct {
+	root *os.Root
+	fd   *os.File // descriptor opened before the priv drop
+	name string
+	path string
+}
+
+// pinFile ...
+func pinFile(path string) (*pinnedFile, error) {
+	dir, name, target, err := pinRoot(path)
+	if err != nil {
+		return nil, err
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := root.Open(name)
+	if err != nil {
+		// absolute symlinks never resolve inside a root, pin the resolved target
+		var terr error
+		if fd, terr = root.Open(target); terr != nil {
+			root.Close()
+			return nil, err
+		}
+		name = target
+	}
+	return &pinnedFile{root: root, fd: fd, name: name, path: path}, nil
+}
+
+// pinRoot returns the pinned root directory, the file name and its resolved symlink target relative to it,
+// the root covers the file and its target [letsencrypt live/*.pem -> ../../archive/*.pem], re-pointed
+// relative symlinks stay visible on reload
+func pinRoot(path string) (dir, name, target string, err error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return _empty, _empty, _empty, err
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return _empty, _empty, _empty, err
+	}
+	dir = filepath.Dir(abs)
+	for !within(filepath.Dir(resolved), dir) {
+		dir = filepath.Dir(dir)
+	}
+	if name, err = filepath.Rel(dir, abs); err != nil {
+		return _empty, _empty, _empty, err
+	}
+	target, err = filepath.Rel(dir, resolved)
+	return dir, name, target, err
+}
+
+// within reports if path is dir or below dir
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// read re-opens the file by name, falls back to the pinned descriptor if the name is unreadable and still
+// refers to the pinned file [in place rewrites]
+func (p *pinnedFile) read() ([]byte, error) {
+	f, err := p.root.Open(p.name)
+	if err == nil {
+		defer f.Close()
+		return io.ReadAll(f)
+	}
+	if !errors.Is(err, fs.ErrPermission) {
+		return nil, err
+	}
+	cur, serr := p.root.Stat(p.name)
+	pinned, perr := p.fd.Stat()
+	if serr != nil || perr != nil || !os.SameFile(cur, pinned) {
+		return nil, err
+	}
+	return io.ReadAll(io.NewSectionReader(p.fd, 0, pinned.Size()))
+}
+
+// close releases the directory handle and the descriptor
+func (p *pinnedFile) close() {
+	p.fd.Close()
+	p.root.Close()
+}
+
+// modTime ...
+func (p *pinnedFile) modTime() (time.Time, error) {
+	fi, err := p.root.Stat(p.name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
@@ -0,0 +1,77 @@
+package npad
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPinFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := func(target, name string) {
+		t.Helper()
+		os.Remove(filepath.Join(dir, name))
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("plain.pem", "plain")
+	write("le/archive/x/cert1.pem", "cert1")
+	write("le/archive/x/cert2.pem", "cert2")
+	write("other/key.pem", "key")
+	os.MkdirAll(filepath.Join(dir, "le/live/x"), 0o700)
+	link("../../archive/x/cert1.pem", "le/live/x/cert.pem")
+	link(filepath.Join(dir, "other/key.pem"), "le/live/x/key.pem")
+	link("missing.pem", "le/live/x/dangling.pem")
+
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{path: "plain.pem", want: "plain"},
+		{path: "le/live/x/cert.pem", want: "cert1"},
+		{path: "le/live/x/key.pem", want: "key"},
+		{path: "le/live/x/dangling.pem", wantErr: true},
+		{path: "missing.pem", wantErr: true},
+	}
+	for _, tc := range tests {
+		f, err := pinFile(filepath.Join(dir, tc.path))
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: pinned", tc.path)
+				f.close()
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.path, err)
+			continue
+		}
+		data, err := f.read()
+		f.close()
+		if err != nil || string(data) != tc.want {
+			t.Errorf("%s: read %q, %v, want %q", tc.path, data, err, tc.want)
+		}
+	}
+
+	// renewal re-points the symlink, reload follows it
+	f, err := pinFile(filepath.Join(dir, "le/live/x/cert.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.close()
+	link("../../archive/x/cert2.pem", "le/live/x/cert.pem")
+	if data, err := f.read(); err != nil || string(data) != "cert2" {
+		t.Fatalf("renewed: read %q, %v", data, err)
+	}
+}
@@ -1,9 +1,10 @@
 module paepcke.de/npad
 
-go 1.24.4
+go 1.25.0
 
 require (
 	github.com/klauspost/compress v1.18.0
+	golang.org/x/crypto v0.39.0
 	mvdan.cc/xurls/v2 v2.6.0
 	paepcke.de/certinfo v0.1.45
 	paepcke.de/logsec v0.1.25
@@ -13,6 +14,5 @@ require (
 
 require (
 	github.com/google/certificate-transparency-go v1.3.2 // indirect
-	golang.org/x/crypto v0.39.0 // indirect
 	golang.org/x/sys v0.33.0 // indirect
 )
@@ -1,8 +1,14 @@
 package npad
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
 	"paepcke.de/logsec"
 	"paepcke.de/npad/compress"
@@ -13,135 +19,314 @@ import (
 //
 
 const (
-	_ua         = "User-Agent"
 	_utf8       = "text/html;charset=utf-8"
 	_txt        = "text/plain"
 	_svg        = "image/svg+xml"
+	_bin        = "application/octet-stream"
 	_ctype      = "Content-Type"
 	_title      = "Title"
 	_err_syntax = "[syntax] ["
 	_err_plain  = "[plain] ["
+	_frame      = 4 * 1024         // html page frame [head|icons|banner] size estimate
+	_maxUpload  = 10 * 1024 * 1024 // largest retention tier
 )
 
-func _headPlain(r http.ResponseWriter) http.ResponseWriter {
+func (c *Config) _headPlain(r http.ResponseWriter) http.ResponseWriter {
 	r.Header().Set(_ctype, _txt)
 	r.Header().Set(_title, c.App)
 	return r
 }
 
-func _headHTML(r http.ResponseWriter) http.ResponseWriter {
+func (c *Config) _headHTML(r http.ResponseWriter) http.ResponseWriter {
 	r.Header().Set(_ctype, _utf8)
 	r.Header().Set(_title, c.App)
 	return r
 }
 
-func _headSVG(r http.ResponseWriter) http.ResponseWriter {
+func (c *Config) _headSVG(r http.ResponseWriter) http.ResponseWriter {
 	r.Header().Set(_ctype, _svg)
 	r.Header().Set(_title, c.App)
 	return r
 }
 
 // plain text display
-func getPlainHandler() http.Handler {
+func (c *Config) getPlainHandler() http.Handler {
 	h := func(r http.ResponseWriter, q *http.Request) {
-		var err error
-		page := ""
+		key := q.URL.Path[c.i.plainOFFSET:]
+		f, ok := negotiate(r, q)
 		switch {
-		case strings.Contains(strings.Join(q.Header[_ua], " "), "curl"):
-			r = _headPlain(r)
-			page, err = getPlainText(q.URL.Path[c.i.plainOFFSET:])
+		case !ok:
+			notAcceptable(r)
+		case f == formatJSON:
+			c.apiRead(r, q, key)
+		case f == formatText:
+			c.plainText(r, q, key)
 		default:
-			r = _headHTML(r)
-			page, err = getPlainHTML(q.URL.Path[c.i.plainOFFSET:])
-		}
-		if err != nil {
-			logsec.LogErr <- _err_plain + err.Error() + "]"
-			http.NotFound(r, q)
-			return
+			ts, t, err := c.loadPaste(q, key)
+			if err != nil {
+				logsec.LogErr <- _err_plain + err.Error() + "]"
+				http.NotFound(r, q)
+				return
+			}
+			defer t.Close()
+			if err = c.writePage(c._headHTML(r), q, _frame+t.size, func(s *pageWriter) { c.getPlainHTML(s, key, ts, t) }); err != nil {
+				logsec.LogErr <- _err_plain + "out] [" + err.Error() + "]"
+			}
 		}
-		compress.WriteTransportCompressedPage(page, r, q, true)
 	}
 	return http.HandlerFunc(h)
 }
 
+// plainText streams the paste chunk by chunk from store via [decrypt] -> [decompress] -> transport
+func (c *Config) plainText(r http.ResponseWriter, q *http.Request, key string) {
+	p, err := c.openPlain(q, key)
+	if err != nil {
+		logsec.LogErr <- _err_plain + err.Error() + "]"
+		http.NotFound(r, q)
+		return
+	}
+	defer p.Close()
+	if compress.Accepts(q, c.i.storeENC) {
+		// stored compressed frame goes out as-is, no decompress & re-compress round trip
+		compress.WriteEncodedPage(p, c.i.storeENC, c._headPlain(r))
+		return
+	}
+	content, err := p.content(false)
+	if err != nil {
+		logsec.LogErr <- _err_plain + err.Error() + "]"
+		internalServerError(r)
+		return
+	}
+	defer content.Close()
+	size := int(p.Size())
+	if c.Clevel > 0 {
+		size = _frame + 4*size // stored compressed, plaintext size unknown upfront, assume common text ratio
+	}
+	if err = c.writePage(c._headPlain(r), q, size, func(s *pageWriter) { getPlainText(s, content) }); err != nil {
+		logsec.LogErr <- _err_plain + "out] [" + err.Error() + "]"
+	}
+}
+
 // syntax display
-func getMagicHandler() http.Handler {
+func (c *Config) getMagicHandler() http.Handler {
 	h := func(r http.ResponseWriter, q *http.Request) {
-		r = _headHTML(r)
-		page, err := getMagicHTML(q.URL.Path[c.i.magicOFFSET:])
-		if err != nil {
-			logsec.LogErr <- _err_syntax + err.Error() + "]"
-			http.NotFound(r, q)
-			return
+		key := q.URL.Path[c.i.magicOFFSET:]
+		f, ok := negotiate(r, q)
+		switch {
+		case !ok:
+			notAcceptable(r)
+		case f == formatJSON:
+			c.apiRead(r, q, key)
+		case f == formatText:
+			c.plainText(r, q, key)
+		default:
+			ts, t, err := c.loadPaste(q, key)
+			if err != nil {
+				logsec.LogErr <- _err_syntax + err.Error() + "]"
+				http.NotFound(r, q)
+				return
+			}
+			defer t.Close()
+			if err = c.writePage(c._headHTML(r), q, _frame+t.size, func(s *pageWriter) { c.getMagicHTML(s, key, ts, t) }); err != nil {
+				logsec.LogErr <- _err_syntax + "out] [" + err.Error() + "]"
+			}
 		}
-		compress.WriteTransportCompressedPage(page, r, q, true)
 	}
 	return http.HandlerFunc(h)
 }
 
 // qr code
-func getQRHandler() http.Handler {
+func (c *Config) getQRHandler() http.Handler {
 	h := func(r http.ResponseWriter, q *http.Request) {
-		r = _headHTML(r)
-		page, err := getQRHTML(q.URL.Path[c.i.qrOFFSET:], q.URL)
+		key := q.URL.Path[c.i.qrOFFSET:]
+		f, ok := negotiate(r, q)
+		if !ok {
+			notAcceptable(r)
+			return
+		}
+		p, err := c.openPlain(q, key)
 		if err != nil {
 			logsec.LogErr <- "[qr] [" + err.Error() + "]"
 			http.NotFound(r, q)
 			return
 		}
-		compress.WriteTransportCompressedPage(page, r, q, true)
+		p.Close()
+		ts, _ := expired(key)
+		switch f {
+		case formatJSON:
+			c.apiWrite(r, q, http.StatusOK, qrTarget{URL: c.i.url + _plain + url.PathEscape(key)})
+		case formatText:
+			err = c.writePage(c._headPlain(r), q, _frame, func(s *pageWriter) { getQRText(s, c.i.url+_plain+url.PathEscape(key)) })
+		default:
+			err = c.writePage(c._headHTML(r), q, _frame, func(s *pageWriter) { c.getQRHTML(s, key, ts, c.i.url+_plain+url.PathEscape(key)) })
+		}
+		if err != nil {
+			logsec.LogErr <- "[qr] [out] [" + err.Error() + "]"
+		}
 	}
 	return http.HandlerFunc(h)
 }
 
-// raw download
-func getDownloadHandler() http.Handler {
+// raw download [range|conditional requests via stored representation, decompressed for clients without the stored encoding]
+func (c *Config) getDownloadHandler() http.Handler {
 	h := func(r http.ResponseWriter, q *http.Request) {
-		page, err := readPaste(q.URL.Path[c.i.downloadOFFSET:], true)
+		p, err := c.openPlain(q, q.URL.Path[c.i.downloadOFFSET:])
 		if err != nil {
 			logsec.LogErr <- err.Error()
 			http.NotFound(r, q)
 			return
 		}
-		name := q.URL.Path[c.i.downloadOFFSET+1:]
-		s := strings.Split(name, "@")
-		if len(s) == 3 {
-			name = s[2]
+		defer p.Close()
+		etag, err := p.etag()
+		if err != nil {
+			logsec.LogErr <- "[download] [etag] [" + err.Error() + "]"
+			internalServerError(r)
+			return
+		}
+		name := p.meta.Name
+		if name == _empty {
+			name = q.URL.Path[c.i.downloadOFFSET+1:]
+			if s := strings.Split(name, "@"); len(s) == 3 {
+				name = s[2]
+			}
+		}
+		ctype := p.meta.Type
+		if ctype == _empty {
+			ctype = _bin
 		}
-		r.Header().Set("Content-disposition", "attachment; filename="+name+compress.GetFileExtension(c.Calgo))
-		compress.WriteTransportCompressedPage(page, r, q, false)
+		r.Header().Add("Vary", "Accept-Encoding")
+		switch {
+		case c.i.storeENC == _empty:
+			r.Header().Set(_ctype, ctype)
+			r.Header().Set("Content-disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+		case compress.Accepts(q, c.i.storeENC):
+			// client decodes the stored frame, deliver the original file, ranges address the encoded frame
+			// Content-Encoding is set at WriteHeader, ServeContent sets Content-Length of the frame or the range
+			r.Header().Set(_ctype, ctype)
+			r.Header().Set("Content-disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+			etag = etag[:len(etag)-1] + "-" + c.i.storeENC + "\""
+			r = &encodedWriter{ResponseWriter: r, enc: c.i.storeENC}
+		default:
+			// client without the stored encoding [curl|wget], decompress on the fly, original file, no ranges
+			r.Header().Set(_ctype, ctype)
+			r.Header().Set("Content-disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+			r.Header().Set("ETag", etag)
+			r.Header().Set("Accept-Ranges", "none")
+			if etagMatch(q.Header.Get("If-None-Match"), etag) {
+				r.WriteHeader(http.StatusNotModified)
+				return
+			}
+			content, err := p.content(false)
+			if err != nil {
+				logsec.LogErr <- "[download] [decompress] [" + err.Error() + "]"
+				internalServerError(r)
+				return
+			}
+			defer content.Close()
+			if q.Method == http.MethodHead {
+				return
+			}
+			if _, err := io.Copy(r, content); err != nil {
+				logsec.LogErr <- "[download] [out] [" + err.Error() + "]"
+			}
+			return
+		}
+		r.Header().Set("ETag", etag)
+		http.ServeContent(r, q, _empty, time.Time{}, p.SectionReader)
 	}
 	return http.HandlerFunc(h)
 }
 
+// etagMatch reports if the If-None-Match header matches etag [weak comparison|*]
+func etagMatch(header, etag string) bool {
+	for _, e := range strings.Split(header, ",") {
+		e = strings.TrimPrefix(strings.TrimSpace(e), "W/")
+		if e == "*" || e == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// encodedWriter adds the Content-Encoding of the stored frame to content responses [200|206]
+type encodedWriter struct {
+	http.ResponseWriter
+	enc string
+}
+
+// WriteHeader ...
+func (w *encodedWriter) WriteHeader(code int) {
+	if code == http.StatusOK || code == http.StatusPartialContent {
+		w.Header().Set("Content-Encoding", w.enc)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
 // input ["start"] page
-func getStartHandler() http.Handler {
+func (c *Config) getStartHandler() http.Handler {
 	h := func(r http.ResponseWriter, q *http.Request) {
-		r = _headHTML(r)
+		r = c._headHTML(r)
 		switch q.Method {
 		case "GET":
-			compress.WriteTransportCompressedPage(getStartHTML(), r, q, true)
-		case "POST":
-			err := q.ParseForm()
+			if err := c.writePage(r, q, _frame, func(s *pageWriter) { c.getStartHTML(s, c.csrfToken(q)) }); err != nil {
+				logsec.LogErr <- "[handler] [/] [out] [" + err.Error() + "]"
+			}
+		case "POST", "PUT":
+			if q.ContentLength > _maxUpload+_frame {
+				requestTooLarge(r, _maxUpload)
+				return
+			}
+			form, err := isFormPost(r, q)
+			if err != nil {
+				logsec.LogInfo <- "[new] [read body] " + err.Error()
+				bodyError(r, err, _maxUpload)
+				return
+			}
+			if q.Method == "PUT" || !form {
+				c.rawUpload(r, q)
+				return
+			}
+			f, err := readForm(r, q)
 			if err != nil {
 				logsec.LogErr <- err.Error() // blocks in case of global [ddos|err rate limit]
-				internalServerError(r)
+				bodyError(r, err, _maxUpload)
 				return
 			}
-			expire, err := atoi(q.FormValue("ex"))
+			if !c.csrfValid(q, f.token) {
+				logsec.LogErr <- "[csrf] [new] [missing or invalid form token]"
+				forbidden(r, "missing or expired form token, reload the upload form")
+				return
+			}
+			acl, err := c.parseACL(f.acl, q)
 			if err != nil {
-				logsec.LogInfo <- "[new] [parse form data] [invalid expire option] " + err.Error()
-				internalServerError(r)
+				logsec.LogInfo <- "[new] [parse form data] [" + err.Error() + "]"
+				http.Error(r, "Error: Bad Request (400) ["+err.Error()+"]", http.StatusBadRequest)
+				return
+			}
+			expire, err := atoi(f.ex)
+			if err != nil || expire < 0 || expire >= len(tierMax) {
+				logsec.LogInfo <- "[new] [parse form data] [invalid expire option] [" + f.ex + "]"
+				http.Error(r, "Error: Bad Request (400) [invalid expire option]", http.StatusBadRequest)
+				return
+			}
+			if !c.checkRole(r, q, uploadRole(expire)) {
 				return
 			}
-			newKey, err := savePaste(q.FormValue("pa"), q.FormValue("na"), expire)
+			newKey, err := c.savePaste(f.in, expire, pasteMeta{Name: f.name, ACL: acl})
 			if err != nil {
 				logsec.LogInfo <- "[new] [save paste] " + err.Error()
-				internalServerError(r)
+				switch {
+				case errors.Is(err, errTooLarge):
+					http.Error(r, "Error: "+err.Error(), http.StatusRequestEntityTooLarge)
+				case errors.Is(err, errExpire):
+					http.Error(r, "Error: Bad Request (400) ["+err.Error()+"]", http.StatusBadRequest)
+				default:
+					internalServerError(r)
+				}
 				return
 			}
 			logsec.LogInfo <- "[new] " + newKey // optional log info event
-			http.Redirect(r, q, _plain+newKey, http.StatusFound)
+			http.Redirect(r, q, c.i.base+_plain+url.PathEscape(newKey), http.StatusFound)
 		default:
 			inf := "Error: Method Not Allowed (405) [" + q.Method + "]"
 			logsec.LogErr <- "[handler] [/] [" + inf + "]"
@@ -151,23 +336,182 @@ func getStartHandler() http.Handler {
 	return http.HandlerFunc(h)
 }
 
+// isFormPost reports browser form submissions, curl --data-binary sends the form content-type
+// as well, so url encoded bodies only count as form if the paste field is present
+func isFormPost(r http.ResponseWriter, q *http.Request) (bool, error) {
+	ct, _, _ := mime.ParseMediaType(q.Header.Get(_ctype))
+	switch ct {
+	case "multipart/form-data":
+		return true, nil
+	case "application/x-www-form-urlencoded":
+		body, err := io.ReadAll(http.MaxBytesReader(r, q.Body, _maxUpload+_frame))
+		if err != nil {
+			return false, err
+		}
+		q.Body = io.NopCloser(bytes.NewReader(body))
+		v, err := url.ParseQuery(string(body))
+		return err == nil && v.Has("pa"), nil
+	}
+	return false, nil
+}
+
+// bodyError maps request body read errors [size limit: 413|other: 400]
+func bodyError(r http.ResponseWriter, err error, limit int) {
+	var m *http.MaxBytesError
+	if errors.As(err, &m) {
+		requestTooLarge(r, limit)
+		return
+	}
+	http.Error(r, "Error: Bad Request (400)", http.StatusBadRequest)
+}
+
+// uploadForm start page form fields
+type uploadForm struct {
+	in    []byte // paste content [text area|file]
+	name  string // optional name [name field|file name]
+	ex    string // expire option
+	token string // csrf form token
+	acl   string // optional private paste access list
+}
+
+// readForm returns the start page form fields [urlencoded|multipart file upload]
+func readForm(r http.ResponseWriter, q *http.Request) (*uploadForm, error) {
+	mr, err := q.MultipartReader()
+	if err == http.ErrNotMultipart {
+		if err = q.ParseForm(); err != nil {
+			return nil, err
+		}
+		return &uploadForm{in: []byte(q.FormValue("pa")), name: q.FormValue("na"), ex: q.FormValue("ex"), token: q.FormValue(_csrfField), acl: q.FormValue("ac")}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f := &uploadForm{}
+	var file []byte
+	var fileName string
+	q.Body = http.MaxBytesReader(r, q.Body, _maxUpload+_frame)
+	for {
+		part, err := mr.NextPart() // streaming, no multipart temp files [chroot|ram-only]
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		v, err := io.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+		switch part.FormName() {
+		case "pa":
+			f.in = v
+		case "na":
+			f.name = string(v)
+		case "ex":
+			f.ex = string(v)
+		case _csrfField:
+			f.token = string(v)
+		case "ac":
+			f.acl = string(v)
+		case "fi":
+			file, fileName = v, part.FileName()
+		}
+		part.Close()
+	}
+	if len(file) > 0 {
+		f.in = file
+		if f.name == _empty {
+			f.name = fileName
+		}
+	}
+	return f, nil
+}
+
+// rawUpload stores the raw request body [curl -T|--data-binary, PUT|POST], expiry & name via query or header
+func (c *Config) rawUpload(r http.ResponseWriter, q *http.Request) {
+	r = c._headPlain(r)
+	name := q.URL.Query().Get("name")
+	if name == _empty {
+		name = q.Header.Get("X-Paste-Name")
+	}
+	if name == _empty && len(q.URL.Path) > 1 {
+		name = q.URL.Path[1:] // curl -T file https://host/ appends the file name
+	}
+	ex := q.URL.Query().Get("expiry")
+	if ex == _empty {
+		ex = q.Header.Get("X-Paste-Expiry")
+	}
+	if ex == _empty {
+		ex = _apiDefault
+	}
+	expire, ok := parseExpiry(ex)
+	if !ok {
+		http.Error(r, "Error: invalid expiry ["+ex+"] [allowed:20m|8h|14d|never]", http.StatusBadRequest)
+		return
+	}
+	if !c.checkRole(r, q, uploadRole(expire)) {
+		return
+	}
+	spec := q.URL.Query().Get("acl")
+	if spec == _empty {
+		spec = q.Header.Get("X-Paste-ACL")
+	}
+	acl, err := c.parseACL(spec, q)
+	if err != nil {
+		http.Error(r, "Error: Bad Request (400) ["+err.Error()+"]", http.StatusBadRequest)
+		return
+	}
+	limit := tierMax[expire]
+	if q.ContentLength > int64(limit) {
+		requestTooLarge(r, limit)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(r, q.Body, int64(limit)))
+	if err != nil {
+		logsec.LogInfo <- "[new] [raw] [read body] " + err.Error()
+		bodyError(r, err, limit)
+		return
+	}
+	newKey, err := c.savePaste(body, expire, pasteMeta{Name: name, ACL: acl})
+	if err != nil {
+		logsec.LogInfo <- "[new] [raw] [save paste] " + err.Error()
+		switch {
+		case errors.Is(err, errTooLarge):
+			http.Error(r, "Error: "+err.Error(), http.StatusRequestEntityTooLarge)
+		case errors.Is(err, errExpire):
+			http.Error(r, "Error: Bad Request (400) ["+err.Error()+"]", http.StatusBadRequest)
+		default:
+			internalServerError(r)
+		}
+		return
+	}
+	logsec.LogInfo <- "[new] [raw] " + newKey
+	newKey = url.PathEscape(newKey)
+	r.Header().Set("Location", c.i.base+_plain+newKey)
+	r.WriteHeader(http.StatusCreated)
+	_, _ = io.WriteString(r, c.i.url+_plain+newKey+_linefeed+c.i.url+_magic+newKey+_linefeed+c.i.url+_download+newKey+_linefeed)
+}
+
 // client connection diagnosis
-func getDiagHandler() http.Handler {
+func (c *Config) getDiagHandler() http.Handler {
 	h := func(r http.ResponseWriter, q *http.Request) {
+		f, ok := negotiate(r, q)
+		if !ok {
+			notAcceptable(r)
+			return
+		}
 		var err error
-		page := ""
-		switch {
-		case strings.Contains(strings.Join(q.Header[_ua], " "), "curl"):
-			r = _headPlain(r)
-			page, err = getDiagText(q)
+		switch f {
+		case formatJSON:
+			c.apiWrite(r, q, http.StatusOK, c.getDiagJSON(q))
+		case formatText:
+			err = c.writePage(c._headPlain(r), q, _frame, func(s *pageWriter) { c.getDiagText(s, q) })
 		default:
-			_headHTML(r)
-			page, err = getDiagHTML(q)
+			err = c.writePage(c._headHTML(r), q, 2*_frame, func(s *pageWriter) { c.getDiagHTML(s, q) })
 		}
 		if err != nil {
 			logsec.LogErr <- "[handler] [/diag] [" + err.Error() + "]"
 		}
-		compress.WriteTransportCompressedPage(page, r, q, true)
 	}
 	return http.HandlerFunc(h)
 }
@@ -181,10 +525,10 @@ func getSourceCodeHandler() http.Handler {
 }
 
 // favicon [shared target cross all pages]
-func getFavIconHandler() http.Handler {
+func (c *Config) getFavIconHandler() http.Handler {
 	h := func(r http.ResponseWriter, q *http.Request) {
-		r = _headSVG(r)
-		compress.WriteTransportCompressedPage(_icon, r, q, true)
+		r = c._headSVG(r)
+		compress.WriteTransportCompressedPage(_icon, r, q, true, c.Tpolicy)
 	}
 	return http.HandlerFunc(h)
 }
@@ -0,0 +1,81 @@
+package npad
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//
+// SECURITY RESPONSE HEADERS MIDDLEWARE
+//
+
+// HeaderPolicy security response header policy [disable single header: <empty>|false|0]
+type HeaderPolicy struct {
+	CSP            string            // Content-Security-Policy
+	FrameOptions   string            // X-Frame-Options [DENY|SAMEORIGIN]
+	ReferrerPolicy string            // Referrer-Policy
+	NoSniff        bool              // X-Content-Type-Options: nosniff
+	CacheControl   string            // Cache-Control, secrets must not land in proxy or browser caches
+	HSTS           time.Duration     // Strict-Transport-Security max-age [tls listener only]
+	HSTSSubdomains bool              // Strict-Transport-Security includeSubDomains
+	Extra          map[string]string // additional static response headers [Permissions-Policy, ...]
+}
+
+// DefaultHeaderPolicy strict policy for the script free ui [inline styles & svg icons only]
+var DefaultHeaderPolicy = HeaderPolicy{
+	CSP:            "default-src 'none'; style-src 'unsafe-inline'; img-src 'self' data:; form-action 'self'; frame-ancestors 'none'; base-uri 'none'",
+	FrameOptions:   "DENY",
+	ReferrerPolicy: "no-referrer",
+	NoSniff:        true,
+	CacheControl:   "no-store",
+	HSTS:           365 * 24 * time.Hour,
+	HSTSSubdomains: false,
+	Extra:          map[string]string{"Cross-Origin-Opener-Policy": "same-origin", "Cross-Origin-Resource-Policy": "same-origin"},
+}
+
+// header ...
+type header struct {
+	key   string
+	value string
+}
+
+// headerList pre-computes the static response header set of the policy
+func (p *HeaderPolicy) headerList() (list []header, hsts string) {
+	add := func(key, value string) {
+		if value != _empty {
+			list = append(list, header{key, value})
+		}
+	}
+	add("Content-Security-Policy", p.CSP)
+	add("X-Frame-Options", p.FrameOptions)
+	add("Referrer-Policy", p.ReferrerPolicy)
+	add("Cache-Control", p.CacheControl)
+	if p.NoSniff {
+		add("X-Content-Type-Options", "nosniff")
+	}
+	for k, v := range p.Extra {
+		add(http.CanonicalHeaderKey(k), v)
+	}
+	if p.HSTS > 0 {
+		hsts = "max-age=" + strconv.FormatInt(int64(p.HSTS/time.Second), 10)
+		if p.HSTSSubdomains {
+			hsts += "; includeSubDomains"
+		}
+	}
+	return list, hsts
+}
+
+// secureHeaders wraps the mux, sets the policy headers on every response, handlers may override
+func (c *Config) secureHeaders(next http.Handler) http.Handler {
+	h := func(r http.ResponseWriter, q *http.Request) {
+		for _, x := range c.i.headers {
+			r.Header().Set(x.key, x.value)
+		}
+		if isHTTPS(q) && c.i.hsts != _empty {
+			r.Header().Set("Strict-Transport-Security", c.i.hsts)
+		}
+		next.ServeHTTP(r, q)
+	}
+	return http.HandlerFunc(h)
+}
@@ -0,0 +1,58 @@
+package npad
+
+import (
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+
+	"paepcke.de/logsec"
+)
+
+//
+// REQUEST SIZE LIMITS & CONNECTION HARDENING
+//
+
+const (
+	_readHeaderTimeout = 5 * time.Second  // slowloris: max time to send the request header
+	_readTimeout       = 2 * time.Minute  // max time to send the complete request [largest upload on slow links]
+	_writeTimeout      = 5 * time.Minute  // max time to deliver the complete response [largest download on slow links]
+	_idleTimeout       = 90 * time.Second // keep-alive idle connection lifetime
+	_maxHeaderBytes    = 32 * 1024        // request header limit [client certs travel in tls, not in header]
+	_retryAfter        = "2"              // 503 retry hint [seconds]
+)
+
+// limitBody caps the request body of a route, handlers apply tighter per retention tier limits
+func limitBody(limit int64, next http.Handler) http.Handler {
+	h := func(r http.ResponseWriter, q *http.Request) {
+		q.Body = http.MaxBytesReader(r, q.Body, limit)
+		next.ServeHTTP(r, q)
+	}
+	return http.HandlerFunc(h)
+}
+
+// limitConcurrency caps parallel requests on expensive [render|qr] routes, excess requests fail fast
+func limitConcurrency(slots chan struct{}, next http.Handler) http.Handler {
+	h := func(r http.ResponseWriter, q *http.Request) {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			next.ServeHTTP(r, q)
+		default:
+			logsec.LogErr <- "[handler] [" + q.URL.Path[:min(len(q.URL.Path), 8)] + "] [busy] [max:" + strconv.Itoa(cap(slots)) + "]"
+			r.Header().Set("Retry-After", _retryAfter)
+			http.Error(r, "Error: Service Unavailable (503) [server busy, try again later]", http.StatusServiceUnavailable)
+		}
+	}
+	return http.HandlerFunc(h)
+}
+
+// heavySlots shared concurrency budget of the expensive routes
+func heavySlots() chan struct{} {
+	return make(chan struct{}, 2*runtime.GOMAXPROCS(0))
+}
+
+// requestTooLarge ...
+func requestTooLarge(r http.ResponseWriter, limit int) {
+	http.Error(r, "Error: Request Entity Too Large (413) [max:"+hruIEC(uint64(limit), "byte")+"]", http.StatusRequestEntityTooLarge)
+}
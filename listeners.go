@@ -0,0 +1,118 @@
+package npad
+
+import (
+	"crypto/tls"
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+
+	"paepcke.de/logsec"
+)
+
+//
+// ADDITIONAL LISTENERS [tls|loopback|unix socket] SHARING THE MUX
+//
+
+const _unixMode fs.FileMode = 0o660
+
+// Transport listener transport
+type Transport int
+
+// listener transports
+const (
+	TransportTLS      Transport = iota // tls|mtls tcp [server tls config]
+	TransportLoopback                  // plaintext tcp, loopback addresses only [local reverse proxy]
+	TransportUnix                      // plaintext unix domain socket [local reverse proxy]
+)
+
+// Listener additional http listener
+type Listener struct {
+	Addr      string      // listen address [name:port] [unix: socket path]
+	Transport Transport   // [TransportTLS|TransportLoopback|TransportUnix]
+	Mode      fs.FileMode // unix socket permissions [default: 0660]
+	GID       int         // unix socket group [disable: 0]
+	Proxy     bool        // expect a proxy protocol [v1|v2] header from TrustedProxies [haproxy send-proxy] [disable: false]
+}
+
+// String ...
+func (t Transport) String() string {
+	switch t {
+	case TransportTLS:
+		return "tls"
+	case TransportLoopback:
+		return "loopback"
+	case TransportUnix:
+		return "unix"
+	}
+	return "invalid:" + itoa(int(t))
+}
+
+// listenAll binds the additional listeners [before the priv drop], any failure closes all
+func (c *Config) listenAll(tlsConf *tls.Config) ([]net.Listener, error) {
+	var out []net.Listener
+	for _, l := range c.Listeners {
+		ln, err := c.bind(l, tlsConf)
+		if err != nil {
+			for _, o := range out {
+				o.Close()
+			}
+			return nil, errors.New("[" + l.Transport.String() + "] [" + l.Addr + "] [" + err.Error() + "]")
+		}
+		logsec.LogInfo <- "[listen] [" + l.Transport.String() + "] [" + l.Addr + "]"
+		out = append(out, ln)
+	}
+	return out, nil
+}
+
+// bind ...
+func (c *Config) bind(l Listener, tlsConf *tls.Config) (net.Listener, error) {
+	switch l.Transport {
+	case TransportTLS:
+		if tlsConf == nil {
+			return nil, errors.New("tls listener needs a server certificate [CAcert|CAkey]")
+		}
+		return c.listen(l.Addr, tlsConf, l.Proxy)
+	case TransportLoopback:
+		ln, err := c.listen(l.Addr, nil, l.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		if a, ok := ln.Addr().(*net.TCPAddr); !ok || !a.IP.IsLoopback() {
+			ln.Close()
+			return nil, errors.New("plaintext tcp listener restricted to loopback addresses")
+		}
+		return ln, nil
+	case TransportUnix:
+		ln, err := listenUnix(l.Addr, l.Mode, l.GID)
+		if err != nil {
+			return nil, err
+		}
+		return c.wrapListener(ln, nil, l.Proxy)
+	}
+	return nil, errors.New("unknown transport")
+}
+
+// listenUnix binds a unix socket [stale sockets are replaced] and applies mode and group
+func listenUnix(path string, mode fs.FileMode, gid int) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&fs.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if mode == 0 {
+		mode = _unixMode
+	}
+	if err = os.Chmod(path, mode); err == nil && gid != 0 {
+		err = os.Chown(path, -1, gid)
+	}
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
@@ -0,0 +1,56 @@
+package npad
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+//
+// PUBLIC BASE URL AND PATH PREFIX MOUNTING
+//
+
+// publicBase pre-computes the public base url [no trailing slash], the mount path and the public origin
+func (c *Config) publicBase(derived string) (base, path, origin string) {
+	origin = derived
+	if u, err := parsePublicURL(c.PublicURL); err == nil && u != nil {
+		origin, path = u.Scheme+"://"+u.Host, u.Path
+	}
+	if c.BasePath != _empty {
+		path = c.BasePath
+	}
+	if path = strings.TrimRight(path, "/"); path != _empty && path[0] != '/' {
+		path = "/" + path
+	}
+	return origin + path, path, origin
+}
+
+// parsePublicURL ... [nil: not configured]
+func parsePublicURL(s string) (*url.URL, error) {
+	if s == _empty {
+		return nil, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == _empty || u.RawQuery != _empty || u.Fragment != _empty {
+		return nil, errors.New("invalid public url [" + s + "] [want: http[s]://host[:port][/path]]")
+	}
+	return u, nil
+}
+
+// mount strips the path prefix, requests without prefix [path stripping proxies] pass unchanged
+func (c *Config) mount(next http.Handler) http.Handler {
+	h := func(r http.ResponseWriter, q *http.Request) {
+		switch p := q.URL.Path; {
+		case c.i.base == _empty:
+		case p == c.i.base:
+			http.Redirect(r, q, c.i.base+"/", http.StatusMovedPermanently)
+			return
+		case strings.HasPrefix(p, c.i.base+"/"):
+			http.StripPrefix(c.i.base, next).ServeHTTP(r, q)
+			return
+		}
+		next.ServeHTTP(r, q)
+	}
+	return http.HandlerFunc(h)
+}
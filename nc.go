@@ -0,0 +1,155 @@
+package npad
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"paepcke.de/logsec"
+)
+
+//
+// RAW TCP ["nc"] UPLOAD LISTENER
+//
+
+const (
+	_ncIdle    = 2 * time.Second  // end of upload if the client stops sending [nc without half-close]
+	_ncTimeout = 30 * time.Second // default max connection time
+	_ncConns   = 32               // max concurrent uploads
+)
+
+// serveNc accepts raw byte streams until [EOF|idle|size limit] and replies the paste url
+func (c *Config) serveNc(l net.Listener) {
+	logsec.LogInfo <- "[nc] [listen] [" + c.NcAddr + "] [expire tier:" + itoa(c.NcExpire) + "]"
+	slots := make(chan struct{}, _ncConns)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logsec.LogErr <- "[nc] [accept] [" + err.Error() + "]"
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+			go func() {
+				c.ncUpload(conn)
+				<-slots
+			}()
+		default:
+			_, _ = io.WriteString(conn, "error: server busy, try again later\n")
+			conn.Close()
+		}
+	}
+}
+
+// ncUpload ...
+func (c *Config) ncUpload(conn net.Conn) {
+	defer conn.Close()
+	client := ncClientID(conn)
+	if c.CAPrivateOnly && ncLeaf(conn) == nil {
+		logsec.LogErr <- "[nc] [" + client + "] [private only] [no verified client certificate]"
+		ncReply(conn, "error: forbidden [verified client certificate required]")
+		return
+	}
+	if ok, wait := c.i.rlCreate.allow(client, time.Now()); !ok {
+		logsec.LogErr <- "[ratelimit] [" + client + "] [nc]"
+		ncReply(conn, "error: too many requests, retry after ["+strconv.Itoa(int(math.Ceil(wait.Seconds())))+"] seconds")
+		return
+	}
+	if role := c.ncRole(conn); !role.has(uploadRole(c.NcExpire)) {
+		logsec.LogErr <- "[roles] [" + client + "] [nc] [role required: " + uploadRole(c.NcExpire).String() + "] [your role: " + role.String() + "]"
+		ncReply(conn, "error: forbidden [role required: "+uploadRole(c.NcExpire).String()+"]")
+		return
+	}
+	timeout := c.NcTimeout
+	if timeout == 0 {
+		timeout = _ncTimeout
+	}
+	limit := c.NcMax
+	if limit == 0 && c.NcExpire >= 0 && c.NcExpire < len(tierMax) {
+		limit = tierMax[c.NcExpire]
+	}
+	if limit == 0 {
+		limit = _maxUpload
+	}
+	deadline := time.Now().Add(timeout)
+	var buf bytes.Buffer
+	b := make([]byte, 32*1024)
+	for {
+		d := time.Now().Add(_ncIdle)
+		if d.After(deadline) {
+			d = deadline
+		}
+		_ = conn.SetReadDeadline(d)
+		n, err := conn.Read(b)
+		buf.Write(b[:n])
+		if buf.Len() > limit {
+			ncReply(conn, "error: input to large [max:"+hruIEC(uint64(limit), "byte")+"]")
+			return
+		}
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) && buf.Len() == 0 && time.Now().Before(deadline) {
+			continue
+		}
+		if err != io.EOF && !errors.Is(err, os.ErrDeadlineExceeded) {
+			logsec.LogErr <- "[nc] [read] [" + conn.RemoteAddr().String() + "] [" + err.Error() + "]"
+			return
+		}
+		break
+	}
+	if buf.Len() == 0 {
+		ncReply(conn, "error: empty upload")
+		return
+	}
+	newKey, err := c.savePaste(buf.Bytes(), c.NcExpire, pasteMeta{})
+	if err != nil {
+		logsec.LogInfo <- "[nc] [save paste] " + err.Error()
+		ncReply(conn, "error: "+err.Error())
+		return
+	}
+	logsec.LogInfo <- "[nc] [new] " + newKey
+	ncReply(conn, c.i.url+_plain+url.PathEscape(newKey))
+}
+
+// ncReply ...
+func ncReply(conn net.Conn, msg string) {
+	_ = conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, _ = io.WriteString(conn, msg+_linefeed)
+}
+
+// ncClientID verified mtls client certificate fingerprint [tls listener], otherwise the client ip
+func ncClientID(conn net.Conn) string {
+	if leaf := ncLeaf(conn); leaf != nil {
+		return "cert:" + certFingerprint(leaf)
+	}
+	return clientIP(conn.RemoteAddr().String())
+}
+
+// ncLeaf completes the tls handshake and returns the verified client certificate [nil: none|plain tcp]
+func ncLeaf(conn net.Conn) *x509.Certificate {
+	t, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	_ = t.SetDeadline(time.Now().Add(_ncIdle))
+	err := t.Handshake()
+	_ = t.SetDeadline(time.Time{})
+	if err != nil {
+		return nil
+	}
+	s := t.ConnectionState()
+	return verifiedLeaf(&s)
+}
@@ -0,0 +1,108 @@
+package npad
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//
+// RESPONSE FORMAT NEGOTIATION [Accept header|?format= override]
+//
+
+// format response representation
+type format uint8
+
+// response formats, order is the server preference on equal client preference
+const (
+	formatText format = iota
+	formatHTML
+	formatJSON
+)
+
+var (
+	// formatNames ?format= override values
+	formatNames = map[string]format{"text": formatText, "txt": formatText, "plain": formatText, "html": formatHTML, "json": formatJSON}
+	// formatTypes media types per format
+	formatTypes = [...][]string{
+		formatText: {"text/plain"},
+		formatHTML: {"text/html", "application/xhtml+xml"},
+		formatJSON: {_json},
+	}
+)
+
+// acceptRange parsed Accept header media range
+type acceptRange struct {
+	typ string
+	q   float64
+}
+
+// negotiate returns the response format, explicit ?format= wins over the Accept header,
+// absent or wildcard only Accept headers [curl|wget|httpie|go|powershell] resolve to text
+func negotiate(r http.ResponseWriter, q *http.Request) (format, bool) {
+	r.Header().Add("Vary", "Accept")
+	if f := q.URL.Query().Get("format"); f != _empty {
+		v, ok := formatNames[strings.ToLower(f)]
+		return v, ok
+	}
+	ranges := parseAccept(strings.Join(q.Header.Values("Accept"), ","))
+	if len(ranges) == 0 {
+		return formatText, true
+	}
+	best, bestQ, bestSpec := formatText, 0.0, -1
+	for f, types := range formatTypes {
+		qv, spec := 0.0, -1
+		for _, t := range types {
+			for _, a := range ranges {
+				if s := matchRange(a.typ, t); s > spec {
+					qv, spec = a.q, s
+				}
+			}
+		}
+		if qv > bestQ || (qv == bestQ && qv > 0 && spec > bestSpec) {
+			best, bestQ, bestSpec = format(f), qv, spec
+		}
+	}
+	return best, bestQ > 0
+}
+
+// parseAccept ...
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		if strings.TrimSpace(part) == _empty {
+			continue
+		}
+		typ, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		qv := 1.0
+		if s, ok := params["q"]; ok {
+			if qv, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, acceptRange{typ: typ, q: qv})
+	}
+	return ranges
+}
+
+// matchRange returns the match specificity of media range against typ [-1:none|0:*/*|1:type/*|2:exact]
+func matchRange(mediaRange, typ string) int {
+	switch {
+	case mediaRange == typ:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(typ, mediaRange[:len(mediaRange)-1]):
+		return 1
+	}
+	return -1
+}
+
+// notAcceptable ...
+func notAcceptable(r http.ResponseWriter) {
+	http.Error(r, "Error: Not Acceptable (406) [supported:text/plain|text/html|application/json] [override:?format=text|html|json]", http.StatusNotAcceptable)
+}
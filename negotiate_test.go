@@ -0,0 +1,49 @@
+package npad
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		accept string
+		want   format
+		ok     bool
+	}{
+		{"no accept", "", "", formatText, true},
+		{"curl wildcard", "", "*/*", formatText, true},
+		{"browser", "", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", formatHTML, true},
+		{"json", "", "application/json", formatJSON, true},
+		{"text", "", "text/plain", formatText, true},
+		{"text wildcard", "", "text/*", formatText, true},
+		{"q prefers json", "", "text/html;q=0.5, application/json", formatJSON, true},
+		{"q prefers html", "", "text/html, application/json;q=0.9", formatHTML, true},
+		{"exact beats wildcard", "", "*/*;q=0.8, application/json;q=0.8", formatJSON, true},
+		{"refused", "", "image/png", formatText, false},
+		{"q zero only", "", "application/json;q=0", formatText, false},
+		{"malformed q skipped", "", "application/json;q=x, text/html", formatHTML, true},
+		{"override json", "?format=json", "text/html", formatJSON, true},
+		{"override case", "?format=HTML", "", formatHTML, true},
+		{"override txt", "?format=txt", "application/json", formatText, true},
+		{"override unknown", "?format=xml", "", formatText, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			q := httptest.NewRequest("GET", "/api/v1/pastes"+tc.query, nil)
+			if tc.accept != _empty {
+				q.Header.Set("Accept", tc.accept)
+			}
+			r := httptest.NewRecorder()
+			got, ok := negotiate(r, q)
+			if ok != tc.ok || (ok && got != tc.want) {
+				t.Fatalf("negotiate = %d, %v, want %d, %v", got, ok, tc.want, tc.ok)
+			}
+			if r.Header().Get("Vary") != "Accept" {
+				t.Fatal("missing Vary: Accept")
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package npad
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+//
+// LOCAL OCSP RESPONDER STAND-IN [pre-fetched der ocsp responses, rfc 6960]
+//
+
+// ocsp cert status
+const (
+	ocspGood = iota
+	ocspRevoked
+	ocspUnknown
+)
+
+const _ocspSkew = 5 * time.Minute // tolerated responder clock skew
+
+// ocspStatus ...
+type ocspStatus struct {
+	status     int
+	revokedAt  time.Time
+	thisUpdate time.Time
+	nextUpdate time.Time
+}
+
+// parseOCSP verifies a der ocsp response for cert [signed by issuer or an issuer delegated responder]
+func parseOCSP(der []byte, cert, issuer *x509.Certificate, now time.Time) (*ocspStatus, error) {
+	resp, err := ocsp.ParseResponseForCert(der, cert, issuer)
+	if err != nil {
+		return nil, errors.New("invalid ocsp response [" + err.Error() + "]")
+	}
+	if resp.Certificate != nil && !bytes.Equal(resp.Certificate.Raw, issuer.Raw) && !hasExtKeyUsage(resp.Certificate, x509.ExtKeyUsageOCSPSigning) {
+		return nil, errors.New("ocsp responder without ocsp signing usage")
+	}
+	if now.Before(resp.ThisUpdate.Add(-_ocspSkew)) || (!resp.NextUpdate.IsZero() && now.After(resp.NextUpdate)) {
+		return nil, errors.New("ocsp response outdated")
+	}
+	s := &ocspStatus{status: ocspUnknown, thisUpdate: resp.ThisUpdate, nextUpdate: resp.NextUpdate}
+	switch {
+	case resp.Status == ocsp.Good:
+		s.status = ocspGood
+	case resp.Status == ocsp.Revoked && !resp.RevokedAt.IsZero():
+		s.status, s.revokedAt = ocspRevoked, resp.RevokedAt
+	}
+	// anything else [unknown|no recognized cert status]: unknown, the revocation policy decides
+	return s, nil
+}
+
+// hasExtKeyUsage ...
+func hasExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, u := range cert.ExtKeyUsage {
+		if u == usage {
+			return true
+		}
+	}
+	return false
+}
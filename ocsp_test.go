@@ -0,0 +1,148 @@
+package npad
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// testCert issued certificate [parent nil: self signed]
+func testCert(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, ca bool, eku ...x509.ExtKeyUsage) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           eku,
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+	}
+	if parent == nil {
+		parent, parentKey = tpl, k
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, parent, &k.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, k
+}
+
+// testOCSP der ocsp response options
+type testOCSP struct {
+	cert       *x509.Certificate
+	issuer     *x509.Certificate
+	signer     *ecdsa.PrivateKey
+	delegate   *x509.Certificate // embedded responder certificate [nil: none]
+	hash       crypto.Hash       // cert id hash
+	status     int               // ocsp.Good|Revoked|Unknown [other: no cert status]
+	thisUpdate time.Time
+	nextUpdate time.Time
+	tamper     bool // flip a signature bit
+}
+
+// der ...
+func (o testOCSP) der(t *testing.T) []byte {
+	t.Helper()
+	responder := o.issuer
+	if o.delegate != nil {
+		responder = o.delegate
+	}
+	der, err := ocsp.CreateResponse(o.issuer, responder, ocsp.Response{
+		Status:       o.status,
+		SerialNumber: o.cert.SerialNumber,
+		IssuerHash:   o.hash,
+		ThisUpdate:   o.thisUpdate,
+		NextUpdate:   o.nextUpdate,
+		RevokedAt:    o.thisUpdate,
+		Certificate:  o.delegate,
+	}, o.signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.tamper {
+		der[len(der)-1] ^= 1 // signature is the last field without embedded certs
+	}
+	return der
+}
+
+func TestParseOCSP(t *testing.T) {
+	ca, caKey := testCert(t, "ca", nil, nil, true)
+	other, otherKey := testCert(t, "other ca", nil, nil, true)
+	leaf, _ := testCert(t, "alice", ca, caKey, false, x509.ExtKeyUsageClientAuth)
+	stranger, _ := testCert(t, "bob", ca, caKey, false, x509.ExtKeyUsageClientAuth)
+	responder, responderKey := testCert(t, "ocsp", ca, caKey, false, x509.ExtKeyUsageOCSPSigning)
+	plainDelegate, plainKey := testCert(t, "no ocsp usage", ca, caKey, false, x509.ExtKeyUsageClientAuth)
+	foreign, foreignKey := testCert(t, "foreign ocsp", other, otherKey, false, x509.ExtKeyUsageOCSPSigning)
+
+	now := time.Now()
+	base := testOCSP{cert: leaf, issuer: ca, signer: caKey, hash: crypto.SHA1, status: ocsp.Good,
+		thisUpdate: now.Add(-time.Minute), nextUpdate: now.Add(time.Hour)}
+	with := func(f func(*testOCSP)) testOCSP {
+		o := base
+		f(&o)
+		return o
+	}
+	tests := []struct {
+		name    string
+		der     []byte
+		cert    *x509.Certificate
+		want    int
+		wantErr bool
+	}{
+		{name: "good", der: base.der(t), want: ocspGood},
+		{name: "revoked", der: with(func(o *testOCSP) { o.status = ocsp.Revoked }).der(t), want: ocspRevoked},
+		{name: "unknown", der: with(func(o *testOCSP) { o.status = ocsp.Unknown }).der(t), want: ocspUnknown},
+		{name: "no cert status", der: with(func(o *testOCSP) { o.status = ocsp.ServerFailed }).der(t), want: ocspUnknown},
+		{name: "sha256 cert id", der: with(func(o *testOCSP) { o.hash = crypto.SHA256 }).der(t), want: ocspGood},
+		{name: "no next update", der: with(func(o *testOCSP) { o.nextUpdate = time.Time{} }).der(t), want: ocspGood},
+		{name: "clock skew tolerated", der: with(func(o *testOCSP) { o.thisUpdate = now.Add(_ocspSkew / 2) }).der(t), want: ocspGood},
+		{name: "delegated responder", der: with(func(o *testOCSP) { o.signer, o.delegate = responderKey, responder }).der(t), want: ocspGood},
+		{name: "expired", der: with(func(o *testOCSP) { o.thisUpdate, o.nextUpdate = now.Add(-2*time.Hour), now.Add(-time.Hour) }).der(t), wantErr: true},
+		{name: "not yet valid", der: with(func(o *testOCSP) { o.thisUpdate = now.Add(2 * _ocspSkew) }).der(t), wantErr: true},
+		{name: "other serial", der: base.der(t), cert: stranger, wantErr: true},
+		{name: "other issuer key", der: with(func(o *testOCSP) { o.signer = otherKey }).der(t), wantErr: true},
+		{name: "tampered signature", der: with(func(o *testOCSP) { o.tamper = true }).der(t), wantErr: true},
+		{name: "delegate without ocsp usage", der: with(func(o *testOCSP) { o.signer, o.delegate = plainKey, plainDelegate }).der(t), wantErr: true},
+		{name: "delegate of other ca", der: with(func(o *testOCSP) { o.signer, o.delegate = foreignKey, foreign }).der(t), wantErr: true},
+		{name: "try later", der: ocsp.TryLaterErrorResponse, wantErr: true},
+		{name: "garbage", der: []byte("no ocsp response"), wantErr: true},
+		{name: "trailing data", der: append(base.der(t), 0), wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cert := leaf
+			if tc.cert != nil {
+				cert = tc.cert
+			}
+			s, err := parseOCSP(tc.der, cert, ca, now)
+			switch {
+			case tc.wantErr && err == nil:
+				t.Fatalf("accepted [status:%d]", s.status)
+			case tc.wantErr:
+			case err != nil:
+				t.Fatal(err)
+			case s.status != tc.want:
+				t.Fatalf("status %d, want %d", s.status, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package npad
+
+import "io/fs"
+
+// fileOwner not available on this platform
+func fileOwner(_ fs.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
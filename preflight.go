@@ -0,0 +1,266 @@
+package npad
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"paepcke.de/certinfo"
+	"paepcke.de/reportstyle"
+)
+
+//
+// DEPLOYMENT PREFLIGHT [no bind, no chroot, no priv drop]
+//
+
+// preflight report builder
+type preflight struct {
+	s  strings.Builder
+	st *reportstyle.Style
+	ok bool
+}
+
+// check adds a pass|fail report line
+func (p *preflight) check(name string, err error, detail string) {
+	state := p.st.PS + "[PASS]" + p.st.PE
+	if err != nil {
+		state, detail, p.ok = p.st.FA+"[FAIL]"+p.st.FE, "["+err.Error()+"]", false
+	}
+	if detail != _empty {
+		state += _space + detail
+	}
+	p.line(pad(name) + " : " + state)
+}
+
+// line adds a styled report line
+func (p *preflight) line(s string) {
+	p.s.WriteString(p.st.L1 + s + p.st.LE)
+}
+
+// Preflight checks the compiled-in config on the deployment target [validation, cert|key match, client ca,
+// server cert expiry, reload file access, chroot dir ownership and permissions, permanent store readability],
+// returns a text report
+func (c *Config) Preflight() (report string, ok bool) {
+	p := &preflight{st: styleText, ok: true}
+	p.s.WriteString(p.st.Start)
+	p.line("npad preflight [" + c.App + "]")
+	p.line(_empty)
+
+	// config validation
+	err := c.Validate()
+	var errs []error
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = j.Unwrap()
+	}
+	for _, e := range errs {
+		name := "Config"
+		var ce *ConfigError
+		if errors.As(e, &ce) {
+			name, e = "Config "+ce.Field, ce.Err
+		}
+		p.check(name, e, _empty)
+	}
+	if err == nil {
+		p.check("Config", nil, "[all fields valid]")
+	}
+
+	// server certificate, key and client ca
+	if c.CAcert != _empty && c.CAkey != _empty {
+		p.certs(c)
+	}
+
+	// pinned files stay readable for the chroot user [hot reload]
+	p.pinned(c)
+
+	// chroot directory and permanent store
+	p.chroot(c)
+
+	status := p.st.PS + "[PASS]" + p.st.PE
+	if !p.ok {
+		status = p.st.FA + "[FAIL]" + p.st.FE
+	}
+	p.line(_empty)
+	p.line(pad("Result") + " : " + status)
+	p.s.WriteString(p.st.End)
+	return p.s.String(), p.ok
+}
+
+// certs ...
+func (p *preflight) certs(c *Config) {
+	key, err := tls.LoadX509KeyPair(c.CAcert, c.CAkey)
+	if err != nil {
+		p.check("Server Cert|Key", err, _empty)
+		return
+	}
+	p.check("Server Cert|Key", nil, "[cert matches key] ["+c.CAcert+"|"+c.CAkey+"]")
+	p.check("Server Cert Expiry", expiry(key.Leaf), "[not after: "+key.Leaf.NotAfter.UTC().Format(time.RFC3339)+"] [days left: "+itoa(int(time.Until(key.Leaf.NotAfter).Hours()/24))+"]")
+	if data, err := os.ReadFile(c.CAcert); err == nil {
+		if s := certinfo.Decode(string(data), &certinfo.Report{Summary: true, Style: p.st}); s != _empty {
+			p.line(_empty)
+			p.s.WriteString(s)
+			p.line(_empty)
+		}
+	}
+	if c.CAclient == _empty {
+		return
+	}
+	data, err := os.ReadFile(c.CAclient)
+	if err != nil {
+		p.check("Client CA", err, _empty)
+		return
+	}
+	cas := parseCerts(data)
+	if len(cas) == 0 {
+		p.check("Client CA", errors.New("no client ca certificate ["+c.CAclient+"]"), _empty)
+		return
+	}
+	p.check("Client CA", nil, "[certificates: "+itoa(len(cas))+"] ["+c.CAclient+"]")
+	for _, ca := range cas {
+		p.check("Client CA Expiry", expiry(ca), "["+ca.Subject.CommonName+"] [not after: "+ca.NotAfter.UTC().Format(time.RFC3339)+"]")
+	}
+}
+
+// expiry ...
+func expiry(cert *x509.Certificate) error {
+	now := time.Now()
+	switch {
+	case now.Before(cert.NotBefore):
+		return errors.New("not yet valid [not before: " + cert.NotBefore.UTC().Format(time.RFC3339) + "]")
+	case now.After(cert.NotAfter):
+		return errors.New("expired [not after: " + cert.NotAfter.UTC().Format(time.RFC3339) + "]")
+	}
+	return nil
+}
+
+// pinned pins the reloaded files [cert|key|clientca|crl] like New does and checks read access of the chroot
+// user, files only readable before the priv drop reload in place rewrites via the pinned descriptor, but miss
+// rotations by rename
+func (p *preflight) pinned(c *Config) {
+	uid, gid := c.dropIDs()
+	var files []string
+	if c.CAcert != _empty && c.CAkey != _empty {
+		files = append(files, c.CAcert, c.CAkey)
+		if c.CAclient != _empty {
+			files = append(files, c.CAclient)
+		}
+	}
+	files = append(files, c.CRLfiles...)
+	for _, name := range files {
+		f, err := pinFile(name)
+		if err != nil {
+			p.check("Reload Access", err, _empty)
+			continue
+		}
+		f.close()
+		target, err := filepath.EvalSymlinks(name)
+		if err != nil {
+			p.check("Reload Access", err, _empty)
+			continue
+		}
+		detail := "[" + name + "]"
+		if target != name {
+			detail += " [-> " + target + "]"
+		}
+		dir, derr := os.Stat(filepath.Dir(target))
+		fi, err := os.Stat(target)
+		switch {
+		case derr != nil:
+			err = derr
+		case err != nil:
+		case !canAccess(dir, uid, gid, 0o1) || !canAccess(fi, uid, gid, 0o4):
+			err = errors.New("unreadable for uid:gid " + itoa(uid) + ":" + itoa(gid) + " " + detail + " [reload only via pinned descriptor, rotation by rename fails]")
+		}
+		p.check("Reload Access", err, detail)
+	}
+}
+
+// dropIDs chroot user uid, gid [0: no priv drop]
+func (c *Config) dropIDs() (uid, gid int) {
+	if c.Chroot == nil {
+		return 0, 0
+	}
+	return c.Chroot.UID, c.Chroot.GID
+}
+
+// chroot checks the chroot directory [owner, mode] and the permanent store access of the chroot user
+func (p *preflight) chroot(c *Config) {
+	dir := "."
+	uid, gid := c.dropIDs()
+	if c.Chroot != nil && c.Chroot.DIR != _empty {
+		dir = c.Chroot.DIR
+	}
+	if dir == "." && !c.PermSTORE {
+		p.check("Chroot Directory", nil, "[disabled]")
+		return
+	}
+	fi, err := os.Stat(dir)
+	if err == nil && !fi.IsDir() {
+		err = errors.New("not a directory [" + dir + "]")
+	}
+	if err != nil {
+		p.check("Chroot Directory", err, _empty)
+		return
+	}
+	owner := "[owner: unknown]"
+	if ou, og, ok := fileOwner(fi); ok {
+		owner = "[owner: " + itoa(ou) + ":" + itoa(og) + "]"
+	}
+	detail := "[" + dir + "] " + owner + " [" + fi.Mode().String() + "]"
+	if fi.Mode().Perm()&0o002 != 0 {
+		err = errors.New("world writable " + detail)
+	}
+	p.check("Chroot Directory", err, detail)
+	if !c.PermSTORE {
+		return
+	}
+
+	// store directory: the chroot user needs rwx, every paste file must stay readable
+	err = nil
+	if !canAccess(fi, uid, gid, 0o7) {
+		err = errors.New("no rwx access for uid:gid [" + itoa(uid) + ":" + itoa(gid) + "] " + detail)
+	}
+	p.check("Store Access", err, "[rwx for uid:gid "+itoa(uid)+":"+itoa(gid)+"]")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		p.check("Store Entries", err, _empty)
+		return
+	}
+	var total int
+	var unreadable []string
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		total++
+		info, err := e.Info()
+		if err != nil || !canAccess(info, uid, gid, 0o4) {
+			unreadable = append(unreadable, e.Name())
+		}
+	}
+	err = nil
+	if len(unreadable) > 0 {
+		err = errors.New(itoa(len(unreadable)) + " of " + itoa(total) + " unreadable for uid " + itoa(uid) + " [" + strings.Join(unreadable[:min(len(unreadable), 5)], "|") + "]")
+	}
+	p.check("Store Entries", err, "[readable: "+itoa(total)+"]")
+}
+
+// canAccess reports if uid:gid holds the need [rwx: 0o7] permission bits on fi [uid 0: no priv drop]
+func canAccess(fi fs.FileInfo, uid, gid int, need fs.FileMode) bool {
+	ou, og, ok := fileOwner(fi)
+	if uid == 0 || !ok {
+		return true
+	}
+	perm := fi.Mode().Perm()
+	switch {
+	case ou == uid:
+		perm >>= 6
+	case og == gid:
+		perm >>= 3
+	}
+	return perm&need == need
+}
@@ -0,0 +1,407 @@
+package npad
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// PROXY PROTOCOL [v1|v2] AND TRUSTED FORWARDERS [X-Forwarded-For|X-Forwarded-Proto]
+//
+
+const (
+	_proxyTimeout = 5 * time.Second // proxy protocol header read timeout
+	_proxyV1Max   = 107             // max v1 header line [incl. crlf]
+	_proxyV2Max   = 4096            // max v2 address and tlv block
+	_proxyUnix    = "unix"          // TrustedProxies entry for unix socket peers
+)
+
+var (
+	proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+	errProxy   = errors.New("invalid proxy protocol header")
+)
+
+// upstreamTLS tls state reported by the proxy [proxy protocol v2 ssl tlv]
+type upstreamTLS struct {
+	Version  string `json:"version,omitempty"`
+	Cipher   string `json:"cipher,omitempty"`
+	CN       string `json:"client_cn,omitempty"`
+	Verified bool   `json:"client_verified"`
+}
+
+// forward client address as reported by a trusted proxy
+type forward struct {
+	Via   string       `json:"via"`   // [proxy-v1|proxy-v2|x-forwarded-for]
+	Peer  string       `json:"peer"`  // proxy address
+	Proto string       `json:"proto"` // client facing scheme [http|https]
+	TLS   *upstreamTLS `json:"tls,omitempty"`
+}
+
+type (
+	proxyConnKey struct{}
+	forwardKey   struct{}
+)
+
+// parseTrustedProxies pre-computes the trusted proxy prefixes [ip|cidr|unix]
+func (c *Config) parseTrustedProxies() error {
+	c.i.proxies, c.i.proxyUnix = nil, false
+	for _, s := range c.TrustedProxies {
+		if s == _proxyUnix {
+			c.i.proxyUnix = true
+			continue
+		}
+		p, err := parseProxy(s)
+		if err != nil {
+			return err
+		}
+		c.i.proxies = append(c.i.proxies, p)
+	}
+	return nil
+}
+
+// parseProxy ip|cidr -> prefix
+func parseProxy(s string) (netip.Prefix, error) {
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		a, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, errors.New("invalid trusted proxy [" + s + "]")
+		}
+		p = netip.PrefixFrom(a, a.BitLen())
+	}
+	return p.Masked(), nil
+}
+
+// trustedPeer reports if addr [host:port|unix socket peer] is a trusted proxy
+func (c *Config) trustedPeer(addr string) bool {
+	if addr == _empty || addr == "@" {
+		return c.i.proxyUnix
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	a, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	a = a.Unmap()
+	for _, p := range c.i.proxies {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyListener expects a proxy protocol header on every connection
+type proxyListener struct {
+	net.Listener
+	c *Config
+}
+
+// Accept ...
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, c: l.c, r: bufio.NewReaderSize(conn, 512)}, nil
+}
+
+// proxyConn reads the proxy protocol header lazily in the connection goroutine [first RemoteAddr|Read call]
+type proxyConn struct {
+	net.Conn
+	c    *Config
+	r    *bufio.Reader
+	once sync.Once
+	err  error
+	src  net.Addr // client address [nil: proxy local command, keep peer]
+	via  string
+	tls  *upstreamTLS
+	mu   sync.Mutex
+	rd   time.Time // read deadline set by the server [restored after the header]
+}
+
+// init ...
+func (p *proxyConn) init() {
+	p.once.Do(func() {
+		if !p.c.trustedPeer(p.Conn.RemoteAddr().String()) {
+			p.err = errors.New("proxy protocol header from untrusted peer [" + p.Conn.RemoteAddr().String() + "]")
+			return
+		}
+		p.mu.Lock()
+		d := time.Now().Add(_proxyTimeout)
+		if !p.rd.IsZero() && p.rd.Before(d) {
+			d = p.rd
+		}
+		_ = p.Conn.SetReadDeadline(d)
+		p.mu.Unlock()
+		p.err = p.readHeader()
+		p.mu.Lock()
+		_ = p.Conn.SetReadDeadline(p.rd)
+		p.mu.Unlock()
+	})
+}
+
+// SetDeadline ...
+func (p *proxyConn) SetDeadline(t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rd = t
+	return p.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline ...
+func (p *proxyConn) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rd = t
+	return p.Conn.SetReadDeadline(t)
+}
+
+// Read ...
+func (p *proxyConn) Read(b []byte) (int, error) {
+	if p.init(); p.err != nil {
+		return 0, p.err
+	}
+	return p.r.Read(b)
+}
+
+// RemoteAddr client address reported by the proxy
+func (p *proxyConn) RemoteAddr() net.Addr {
+	if p.init(); p.src != nil {
+		return p.src
+	}
+	return p.Conn.RemoteAddr()
+}
+
+// readHeader ...
+func (p *proxyConn) readHeader() error {
+	sig, err := p.r.Peek(len(proxyV2Sig))
+	if err == nil && bytes.Equal(sig, proxyV2Sig) {
+		p.via = "proxy-v2"
+		return p.readV2()
+	}
+	p.via = "proxy-v1"
+	return p.readV1()
+}
+
+// readV1 PROXY <TCP4|TCP6|UNKNOWN> <src> <dst> <sport> <dport>\r\n
+func (p *proxyConn) readV1() error {
+	var line []byte
+	for len(line) < _proxyV1Max {
+		b, err := p.r.ReadByte()
+		if err != nil {
+			return errProxy
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	f := strings.Fields(strings.TrimSuffix(string(line), "\r\n"))
+	switch {
+	case !bytes.HasSuffix(line, []byte("\r\n")) || len(f) < 2 || f[0] != "PROXY":
+		return errProxy
+	case f[1] == "UNKNOWN":
+		return nil
+	case len(f) != 6 || (f[1] != "TCP4" && f[1] != "TCP6"):
+		return errProxy
+	}
+	ip, err := netip.ParseAddr(f[2])
+	if err != nil || ip.Is4() != (f[1] == "TCP4") {
+		return errProxy
+	}
+	port, err := strconv.ParseUint(f[4], 10, 16)
+	if err != nil {
+		return errProxy
+	}
+	p.src = net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port)))
+	return nil
+}
+
+// readV2 binary header: signature, version|command, family|protocol, length, addresses, tlvs
+func (p *proxyConn) readV2() error {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(p.r, hdr); err != nil {
+		return errProxy
+	}
+	size := int(binary.BigEndian.Uint16(hdr[14:]))
+	if hdr[12]>>4 != 2 || size > _proxyV2Max {
+		return errProxy
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(p.r, data); err != nil {
+		return errProxy
+	}
+	switch hdr[12] & 0x0f {
+	case 0x0: // local [health checks]
+		return nil
+	case 0x1: // proxy
+	default:
+		return errProxy
+	}
+	var tlvs []byte
+	switch hdr[13] {
+	case 0x11: // tcp4
+		if size < 12 {
+			return errProxy
+		}
+		ip, _ := netip.AddrFromSlice(data[0:4])
+		p.src = net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, binary.BigEndian.Uint16(data[8:])))
+		tlvs = data[12:]
+	case 0x21: // tcp6
+		if size < 36 {
+			return errProxy
+		}
+		ip, _ := netip.AddrFromSlice(data[0:16])
+		p.src = net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip.Unmap(), binary.BigEndian.Uint16(data[32:])))
+		tlvs = data[36:]
+	default: // unspec|unix|udp, keep peer
+		return nil
+	}
+	p.tls = parseProxySSL(tlvs)
+	return nil
+}
+
+// parseProxySSL extracts the PP2_TYPE_SSL tlv [nil: none|client did not use tls]
+func parseProxySSL(tlvs []byte) *upstreamTLS {
+	for len(tlvs) >= 3 {
+		typ, size := tlvs[0], int(binary.BigEndian.Uint16(tlvs[1:]))
+		if len(tlvs) < 3+size {
+			return nil
+		}
+		v := tlvs[3 : 3+size]
+		tlvs = tlvs[3+size:]
+		if typ != 0x20 || size < 5 || v[0]&0x01 == 0 {
+			continue
+		}
+		t := &upstreamTLS{Verified: v[0]&0x06 != 0 && binary.BigEndian.Uint32(v[1:]) == 0}
+		for sub := v[5:]; len(sub) >= 3; {
+			st, ss := sub[0], int(binary.BigEndian.Uint16(sub[1:]))
+			if len(sub) < 3+ss {
+				break
+			}
+			val := string(sub[3 : 3+ss])
+			sub = sub[3+ss:]
+			switch st {
+			case 0x21:
+				t.Version = val
+			case 0x22:
+				t.CN = val
+			case 0x23:
+				t.Cipher = val
+			}
+		}
+		return t
+	}
+	return nil
+}
+
+// proxyConnContext http.Server ConnContext hook, keeps the proxy connection for the forwarded handler
+func proxyConnContext(ctx context.Context, conn net.Conn) context.Context {
+	if t, ok := conn.(*tls.Conn); ok {
+		conn = t.NetConn()
+	}
+	if p, ok := conn.(*proxyConn); ok {
+		return context.WithValue(ctx, proxyConnKey{}, p)
+	}
+	return ctx
+}
+
+// forwarded replaces the remote address with the client address reported by trusted proxies
+// [proxy protocol|right-most untrusted X-Forwarded-For entry]
+func (c *Config) forwarded(next http.Handler) http.Handler {
+	h := func(r http.ResponseWriter, q *http.Request) {
+		var fw *forward
+		if p, ok := q.Context().Value(proxyConnKey{}).(*proxyConn); ok && p.src != nil {
+			fw = &forward{Via: p.via, Peer: p.Conn.RemoteAddr().String(), Proto: "http", TLS: p.tls}
+			if p.tls != nil {
+				fw.Proto = "https"
+			}
+		}
+		if client := c.forwardedFor(q); client != _empty {
+			proto := "http"
+			if strings.EqualFold(q.Header.Get("X-Forwarded-Proto"), "https") || q.TLS != nil {
+				proto = "https"
+			}
+			if fw == nil {
+				fw = &forward{}
+			}
+			fw.Via, fw.Peer, fw.Proto = "x-forwarded-for", q.RemoteAddr, proto
+			q = q.WithContext(q.Context())
+			q.RemoteAddr = client
+		}
+		if fw != nil {
+			q = q.WithContext(context.WithValue(q.Context(), forwardKey{}, fw))
+		}
+		next.ServeHTTP(r, q)
+	}
+	return http.HandlerFunc(h)
+}
+
+// forwardedFor right-most untrusted X-Forwarded-For address [<empty>: untrusted peer|no valid entry]
+func (c *Config) forwardedFor(q *http.Request) string {
+	xff := q.Header.Values("X-Forwarded-For")
+	if len(xff) == 0 || !c.trustedPeer(q.RemoteAddr) {
+		return _empty
+	}
+	hops := strings.Split(strings.Join(xff, ","), ",")
+	client := _empty
+	for i := len(hops) - 1; i >= 0; i-- {
+		a, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = net.JoinHostPort(a.Unmap().String(), "0")
+		if !c.trustedPeer(client) {
+			break
+		}
+	}
+	return client
+}
+
+// forwardedBy client address details reported by a trusted proxy [nil: direct connection]
+func forwardedBy(q *http.Request) *forward {
+	fw, _ := q.Context().Value(forwardKey{}).(*forward)
+	return fw
+}
+
+// isHTTPS direct tls or client facing tls at a trusted proxy
+func isHTTPS(q *http.Request) bool {
+	if q.TLS != nil {
+		return true
+	}
+	fw := forwardedBy(q)
+	return fw != nil && fw.Proto == "https"
+}
+
+// getDiagForward proxy details
+func getDiagForward(q *http.Request) string {
+	fw := forwardedBy(q)
+	if fw == nil {
+		return _empty
+	}
+	out := pad("Client Address") + " : " + q.RemoteAddr + _linefeed
+	out += pad("Forwarded By") + " : " + fw.Peer + " [" + fw.Via + "] [" + fw.Proto + "]" + _linefeed
+	if t := fw.TLS; t != nil {
+		out += pad("Upstream TLS") + " : " + t.Version + " " + t.Cipher + _linefeed
+		if t.CN != _empty {
+			out += pad("Upstream Client CN") + " : " + t.CN + " [verified:" + strconv.FormatBool(t.Verified) + "]" + _linefeed
+		}
+	}
+	return out
+}
@@ -0,0 +1,189 @@
+package npad
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+)
+
+// proxyV2 builds a proxy protocol v2 header [cmd: 0 local|1 proxy] [fam: 0x11 tcp4|0x21 tcp6|...]
+func proxyV2(cmd, fam byte, addr []byte, tlvs ...[]byte) []byte {
+	body := bytes.Clone(addr)
+	for _, tlv := range tlvs {
+		body = append(body, tlv...)
+	}
+	b := append(bytes.Clone(proxyV2Sig), 0x20|cmd, fam, 0, 0)
+	binary.BigEndian.PutUint16(b[14:], uint16(len(body)))
+	return append(b, body...)
+}
+
+// proxyTLV ...
+func proxyTLV(typ byte, v []byte) []byte {
+	return append([]byte{typ, byte(len(v) >> 8), byte(len(v))}, v...)
+}
+
+func TestProxyHeader(t *testing.T) {
+	tcp4 := append(netip.MustParseAddr("192.0.2.7").AsSlice(), netip.MustParseAddr("198.51.100.1").AsSlice()...)
+	tcp4 = binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(tcp4, 51000), 443)
+	tcp6 := append(netip.MustParseAddr("2001:db8::7").AsSlice(), netip.MustParseAddr("2001:db8::1").AsSlice()...)
+	tcp6 = binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(tcp6, 51000), 443)
+	ssl := func(client byte, verify uint32, sub ...[]byte) []byte {
+		v := binary.BigEndian.AppendUint32([]byte{client}, verify)
+		for _, s := range sub {
+			v = append(v, s...)
+		}
+		return proxyTLV(0x20, v)
+	}
+	tests := []struct {
+		name    string
+		in      []byte
+		via     string
+		src     string // <empty>: keep peer
+		tls     *upstreamTLS
+		wantErr bool
+	}{
+		{name: "v1 tcp4", in: []byte("PROXY TCP4 192.0.2.7 198.51.100.1 51000 443\r\nGET"), via: "proxy-v1", src: "192.0.2.7:51000"},
+		{name: "v1 tcp6", in: []byte("PROXY TCP6 2001:db8::7 2001:db8::1 51000 443\r\n"), via: "proxy-v1", src: "[2001:db8::7]:51000"},
+		{name: "v1 unknown", in: []byte("PROXY UNKNOWN\r\n"), via: "proxy-v1"},
+		{name: "v1 family mismatch", in: []byte("PROXY TCP4 2001:db8::7 2001:db8::1 51000 443\r\n"), wantErr: true},
+		{name: "v1 bad port", in: []byte("PROXY TCP4 192.0.2.7 198.51.100.1 70000 443\r\n"), wantErr: true},
+		{name: "v1 missing fields", in: []byte("PROXY TCP4 192.0.2.7\r\n"), wantErr: true},
+		{name: "v1 no crlf", in: []byte("PROXY TCP4 192.0.2.7 198.51.100.1 51000 443\n"), wantErr: true},
+		{name: "v1 too long", in: append([]byte("PROXY TCP4 "), bytes.Repeat([]byte{' '}, _proxyV1Max)...), wantErr: true},
+		{name: "no header", in: []byte("GET / HTTP/1.1\r\n"), wantErr: true},
+		{name: "v2 tcp4", in: proxyV2(1, 0x11, tcp4), via: "proxy-v2", src: "192.0.2.7:51000"},
+		{name: "v2 tcp6", in: proxyV2(1, 0x21, tcp6), via: "proxy-v2", src: "[2001:db8::7]:51000"},
+		{name: "v2 local", in: proxyV2(0, 0x00, nil), via: "proxy-v2"},
+		{name: "v2 unix keeps peer", in: proxyV2(1, 0x31, make([]byte, 216)), via: "proxy-v2"},
+		{name: "v2 short tcp4", in: proxyV2(1, 0x11, tcp4[:8]), wantErr: true},
+		{name: "v2 bad command", in: proxyV2(2, 0x11, tcp4), wantErr: true},
+		{name: "v2 truncated", in: proxyV2(1, 0x11, tcp4)[:20], wantErr: true},
+		{name: "v2 tls verified", in: proxyV2(1, 0x11, tcp4, proxyTLV(0x04, []byte("noop")),
+			ssl(0x07, 0, proxyTLV(0x21, []byte("TLSv1.3")), proxyTLV(0x22, []byte("alice")), proxyTLV(0x23, []byte("TLS_AES_128_GCM_SHA256")))),
+			via: "proxy-v2", src: "192.0.2.7:51000", tls: &upstreamTLS{Version: "TLSv1.3", Cipher: "TLS_AES_128_GCM_SHA256", CN: "alice", Verified: true}},
+		{name: "v2 tls verify failed", in: proxyV2(1, 0x11, tcp4, ssl(0x07, 1)), via: "proxy-v2", src: "192.0.2.7:51000", tls: &upstreamTLS{}},
+		{name: "v2 plain client", in: proxyV2(1, 0x11, tcp4, ssl(0x00, 0)), via: "proxy-v2", src: "192.0.2.7:51000"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &proxyConn{r: bufio.NewReaderSize(bytes.NewReader(tc.in), 512)}
+			err := p.readHeader()
+			if tc.wantErr {
+				if !errors.Is(err, errProxy) {
+					t.Fatalf("want errProxy, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			src := _empty
+			if p.src != nil {
+				src = p.src.String()
+			}
+			if p.via != tc.via || src != tc.src {
+				t.Fatalf("via %q src %q, want %q %q", p.via, src, tc.via, tc.src)
+			}
+			if (p.tls == nil) != (tc.tls == nil) || (p.tls != nil && *p.tls != *tc.tls) {
+				t.Fatalf("tls %+v, want %+v", p.tls, tc.tls)
+			}
+		})
+	}
+
+	// the request bytes after the header stay readable
+	p := &proxyConn{r: bufio.NewReaderSize(bytes.NewReader([]byte("PROXY UNKNOWN\r\nGET /")), 512)}
+	if err := p.readHeader(); err != nil {
+		t.Fatal(err)
+	}
+	if rest, _ := io.ReadAll(p.r); string(rest) != "GET /" {
+		t.Fatalf("rest %q", rest)
+	}
+}
+
+func TestProxyDeadline(t *testing.T) {
+	c := &Config{TrustedProxies: []string{"127.0.0.1"}}
+	if err := c.parseTrustedProxies(); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accept := func(header string) (*proxyConn, net.Conn) {
+		t.Helper()
+		client, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		guard := time.AfterFunc(2*time.Second, func() { client.Close() }) // fail instead of hang on a lost deadline
+		t.Cleanup(func() { guard.Stop(); client.Close() })
+		if header != _empty {
+			if _, err = client.Write([]byte(header)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		conn, err := (&proxyListener{Listener: l, c: c}).Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn.(*proxyConn), client
+	}
+
+	// the server read deadline survives the header
+	p, _ := accept("PROXY TCP4 192.0.2.7 198.51.100.1 51000 443\r\n")
+	if err := p.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if a := p.RemoteAddr().String(); a != "192.0.2.7:51000" {
+		t.Fatalf("remote %s", a)
+	}
+	start := time.Now()
+	if _, err := p.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("want deadline exceeded, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("server deadline lost [blocked %s]", d)
+	}
+
+	// an earlier server deadline bounds the header read
+	p, _ = accept(_empty)
+	if err := p.SetDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	start = time.Now()
+	if _, err := p.Read(make([]byte, 1)); !errors.Is(err, errProxy) {
+		t.Fatalf("want errProxy, got %v", err)
+	}
+	if d := time.Since(start); d >= _proxyTimeout {
+		t.Fatalf("header read ignored the server deadline [%s]", d)
+	}
+}
+
+func TestParseProxy(t *testing.T) {
+	tests := []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{in: "127.0.0.1", want: "127.0.0.1/32"},
+		{in: "::1", want: "::1/128"},
+		{in: "10.1.2.3/8", want: "10.0.0.0/8"},
+		{in: "2001:db8::1/64", want: "2001:db8::/64"},
+		{in: "localhost", wantErr: true},
+		{in: "10.0.0.0/33", wantErr: true},
+	}
+	for _, tc := range tests {
+		p, err := parseProxy(tc.in)
+		if (err != nil) != tc.wantErr || (err == nil && p.String() != tc.want) {
+			t.Errorf("parseProxy(%q) = %v, %v, want %q", tc.in, p, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package npad
+
+import (
+	"context"
+	"crypto/tls"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"paepcke.de/logsec"
+)
+
+//
+// PER CLIENT RATE LIMITS [token bucket per client ip|mtls certificate fingerprint]
+//
+
+// Rate token bucket [PerSec: refill rate, Burst: bucket size] [disable: 0]
+type Rate struct {
+	PerSec float64 // sustained requests per second
+	Burst  int     // max requests in a row
+}
+
+// RateLimits per client rate limits by request class
+type RateLimits struct {
+	Create Rate          // uploads [form|raw|api|nc]
+	Read   Rate          // paste views, downloads, qr, api read|delete, start page
+	Diag   Rate          // connection diagnosis
+	Idle   time.Duration // evict idle client buckets after [default: 10m]
+}
+
+// DefaultRateLimits ...
+var DefaultRateLimits = RateLimits{
+	Create: Rate{PerSec: 0.2, Burst: 10},
+	Read:   Rate{PerSec: 5, Burst: 50},
+	Diag:   Rate{PerSec: 0.5, Burst: 5},
+	Idle:   10 * time.Minute,
+}
+
+const _rateIdle = 10 * time.Minute
+
+// bucket ...
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter token buckets of one request class [nil: unlimited]
+type limiter struct {
+	rate    Rate
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// newLimiter ...
+func newLimiter(r Rate) *limiter {
+	if r.PerSec <= 0 || r.Burst <= 0 {
+		return nil
+	}
+	return &limiter{rate: r, buckets: make(map[string]*bucket)}
+}
+
+// allow takes a token from the client bucket, returns the wait time until the next token otherwise
+func (l *limiter) allow(client string, now time.Time) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: float64(l.rate.Burst), last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(float64(l.rate.Burst), b.tokens+now.Sub(b.last).Seconds()*l.rate.PerSec)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate.PerSec * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// evict removes buckets idle for longer than idle, refilled buckets carry no state
+func (l *limiter) evict(idle time.Duration, now time.Time) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for k, b := range l.buckets {
+		if now.Sub(b.last) > idle {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// clients number of tracked client buckets
+func (l *limiter) clients() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// rateAutoGC evicts idle client buckets
+func (c *Config) rateAutoGC(ctx context.Context) {
+	idle := c.Rlimit.Idle
+	if idle <= 0 {
+		idle = _rateIdle
+	}
+	for sleep(ctx, idle) {
+		now := time.Now()
+		c.i.rlCreate.evict(idle, now)
+		c.i.rlRead.evict(idle, now)
+		c.i.rlDiag.evict(idle, now)
+	}
+}
+
+// clientID verified mtls client certificate fingerprint, otherwise the client ip [ipv6: /64 prefix]
+func clientID(q *http.Request) string {
+	if id := certID(q.TLS); id != _empty {
+		return id
+	}
+	return clientIP(q.RemoteAddr)
+}
+
+// certID verified client certificate sha256 fingerprint [<empty>: no verified client certificate]
+func certID(s *tls.ConnectionState) string {
+	leaf := verifiedLeaf(s)
+	if leaf == nil {
+		return _empty
+	}
+	return "cert:" + certFingerprint(leaf)
+}
+
+// clientIP ...
+func clientIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip.To4() == nil {
+		return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+	}
+	return ip.String()
+}
+
+// rateLimit enforces the diag limit on diag routes, otherwise the create limit on uploads [POST|PUT] or the read limit
+func (c *Config) rateLimit(diag bool, next http.Handler) http.Handler {
+	h := func(r http.ResponseWriter, q *http.Request) {
+		l := c.i.rlRead
+		switch {
+		case diag:
+			l = c.i.rlDiag
+		case q.Method == http.MethodPost || q.Method == http.MethodPut:
+			l = c.i.rlCreate
+		}
+		client := clientID(q)
+		ok, wait := l.allow(client, time.Now())
+		if ok {
+			next.ServeHTTP(r, q)
+			return
+		}
+		logsec.LogErr <- "[ratelimit] [" + client + "] [" + q.Method + "] [" + q.URL.Path[:min(len(q.URL.Path), 8)] + "]"
+		r.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		if strings.HasPrefix(q.URL.Path, _api) {
+			apiFail(r, http.StatusTooManyRequests, "rate_limited", "too many requests, retry after ["+r.Header().Get("Retry-After")+"] seconds")
+			return
+		}
+		http.Error(r, "Error: Too Many Requests (429) [retry after: "+r.Header().Get("Retry-After")+"s]", http.StatusTooManyRequests)
+	}
+	return http.HandlerFunc(h)
+}
@@ -0,0 +1,80 @@
+package npad
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testLeaf self signed client certificate [cn|email]
+func testLeaf(t *testing.T, cn, email string) *x509.Certificate {
+	t.Helper()
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if email != _empty {
+		tpl.EmailAddresses = []string{email}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &k.PublicKey, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// testRequest request with verified client certificate [nil: none]
+func testRequest(leaf *x509.Certificate) *http.Request {
+	q := httptest.NewRequest("GET", "/", nil)
+	if leaf != nil {
+		q.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf}}}
+	}
+	return q
+}
+
+func TestClientID(t *testing.T) {
+	leaf := testLeaf(t, "alice", _empty)
+	tests := []struct {
+		name   string
+		remote string
+		leaf   *x509.Certificate
+		want   string
+	}{
+		{"ipv4", "192.0.2.7:51000", nil, "192.0.2.7"},
+		{"ipv4 no port", "192.0.2.7", nil, "192.0.2.7"},
+		{"ipv6 /64 bucket", "[2001:db8:1:2:aaaa:bbbb:cccc:dddd]:51000", nil, "2001:db8:1:2::/64"},
+		{"ipv6 same /64", "[2001:db8:1:2::1]:443", nil, "2001:db8:1:2::/64"},
+		{"ipv6 next /64", "[2001:db8:1:3::1]:443", nil, "2001:db8:1:3::/64"},
+		{"ipv6 no port", "2001:db8:1:2::ffff", nil, "2001:db8:1:2::/64"},
+		{"ipv4 mapped", "[::ffff:192.0.2.7]:51000", nil, "192.0.2.7"},
+		{"loopback v6", "[::1]:51000", nil, "::/64"},
+		{"unix peer", "@", nil, "@"},
+		{"client cert wins", "192.0.2.7:51000", leaf, "cert:" + certFingerprint(leaf)},
+	}
+	for _, tc := range tests {
+		q := testRequest(tc.leaf)
+		q.RemoteAddr = tc.remote
+		if got := clientID(q); got != tc.want {
+			t.Errorf("%s: clientID(%q) = %q, want %q", tc.name, tc.remote, got, tc.want)
+		}
+	}
+}
@@ -1,17 +1,24 @@
 package npad
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
 	"errors"
 	"html"
+	"io"
 	"net/http"
 	"net/url"
 	"sort"
 	"strings"
+	"text/template"
 	"time"
+	"unicode/utf8"
 
 	"mvdan.cc/xurls/v2"
 	"paepcke.de/certinfo"
 	"paepcke.de/logsec"
+	"paepcke.de/npad/compress"
 	"paepcke.de/npad/syntax"
 	"paepcke.de/npad/url2svg"
 	"paepcke.de/reportstyle"
@@ -32,141 +39,219 @@ var (
 	styleHTML  = reportstyle.StyleHTML()
 	styleText  = reportstyle.StyleText()
 	errExpired = errors.New("paste expired")
+	_binary    = "[binary content] [use download]"
+)
+
+const (
+	_sniff     = 4 * 1024    // binary check window [paste head]
+	_magicScan = 1024 * 1024 // magic page url list|certificate decode window
 )
 
 func internalServerError(r http.ResponseWriter) {
 	http.Error(r, "Error: Internal Server Error (500)", http.StatusInternalServerError)
 }
 
-func getStartHTML() string {
-	var s strings.Builder
+func (c *Config) getStartHTML(s *pageWriter, token string) {
 	s.WriteString(c.i.head1)
 	s.WriteString(body)
 	s.WriteString(i1)
 	s.WriteString(c.i.banner)
-	s.WriteString(form)
+	s.WriteString(formHead)
+	s.WriteString(token)
+	s.WriteString(formTail)
 	s.WriteString(endBody)
-	return s.String()
 }
 
-func getPlainHTML(key string) (string, error) {
-	ts, isExpired := expired(key)
-	if isExpired {
-		return _empty, errExpired
+// openPlain checks expire state and access list, opens the stored paste for streaming
+func (c *Config) openPlain(q *http.Request, key string) (*pasteReader, error) {
+	if _, isExpired := expired(key); isExpired {
+		return nil, errExpired
 	}
-	p, err := readPaste(key, false)
+	p, err := c.openPaste(key)
 	if err != nil {
-		return _empty, err
+		return nil, err
 	}
-	var name string
-	sp := strings.Split(key, "@")
-	if len(sp) == 3 {
-		name = sp[2] + _space
+	if err = authorize(q, p.meta); err != nil {
+		p.Close()
+		return nil, err
 	}
-	name += "[Size:" + hruIEC(uint64(len(p)), "byte") + "]\n\n"
-	var s strings.Builder
+	return p, nil
+}
+
+// pasteText decompressed paste stream for the html renderers
+type pasteText struct {
+	p       *pasteReader
+	content io.ReadCloser
+	r       *bufio.Reader
+	size    int  // paste size [bytes] [legacy pastes: stored size]
+	text    bool // utf8 paste head
+}
+
+// Close ...
+func (t *pasteText) Close() error {
+	t.content.Close()
+	return t.p.Close()
+}
+
+// copyHTML streams the html escaped rest of the paste
+func (t *pasteText) copyHTML(s *pageWriter) {
+	if _, err := io.Copy(htmlEscaper{s}, t.r); err != nil && s.err == nil {
+		s.err = err
+	}
+}
+
+// htmlEscaper html escapes every write
+type htmlEscaper struct{ w io.Writer }
+
+// Write ...
+func (e htmlEscaper) Write(p []byte) (int, error) {
+	template.HTMLEscape(e.w, p)
+	return len(p), nil
+}
+
+// capBuffer keeps the first max bytes written
+type capBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+// Write ...
+func (b *capBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
+
+// isText utf8 check of the paste head [a rune cut at the window end is fine]
+func isText(head []byte) bool {
+	for i := 0; i < utf8.UTFMax && len(head) > 0; i++ {
+		if utf8.Valid(head) {
+			return true
+		}
+		head = head[:len(head)-1]
+	}
+	return utf8.Valid(head)
+}
+
+// loadPaste returns expire info and the opened paste stream, all store|access errors surface before any page byte is sent
+func (c *Config) loadPaste(q *http.Request, key string) (string, *pasteText, error) {
+	ts, _ := expired(key)
+	p, err := c.openPlain(q, key)
+	if err != nil {
+		return _empty, nil, err
+	}
+	content, err := p.content(false)
+	if err != nil {
+		p.Close()
+		return _empty, nil, err
+	}
+	t := &pasteText{p: p, content: content, r: bufio.NewReaderSize(content, _sniff), size: p.meta.Size}
+	if t.size == 0 {
+		t.size = int(p.Size())
+	}
+	head, _ := t.r.Peek(_sniff)
+	t.text = isText(head)
+	return ts, t, nil
+}
+
+func (c *Config) getPlainHTML(s *pageWriter, key, ts string, t *pasteText) {
 	s.WriteString(c.i.head2)
 	s.WriteString(body)
 	s.WriteString(i2)
 	s.WriteString(c.i.banner)
-	s.WriteString(button(key, ts))
+	s.WriteString(c.button(key, ts))
 	s.WriteString(pre)
-	s.WriteString(name)
-	s.WriteString(html.EscapeString(p))
+	s.WriteString(pasteName(key, t.size))
+	switch t.text {
+	case true:
+		t.copyHTML(s)
+	default:
+		s.WriteString(_binary)
+	}
 	s.WriteString(endPre)
 	s.WriteString(endBody)
-	return s.String(), nil
 }
 
-func getMagicHTML(key string) (string, error) {
-	ts, isExpired := expired(key)
-	if isExpired {
-		return _empty, errExpired
-	}
-	p, err := readPaste(key, false)
-	if err != nil {
-		return _empty, err
-	}
-	var name string
-	sp := strings.Split(key, "@")
-	if len(sp) == 3 {
-		name = sp[2] + _space
-	}
-	name += "[Size:" + hruIEC(uint64(len(p)), "byte") + "]\n\n"
-	var opt strings.Builder
-	var certqr string
-	switch len(p) > 32 && (strings.Contains(p[:32], "BEGIN ") || strings.Contains(p[:32], "ssh-")) {
-	case true:
-		if len(p) < 1024 {
-			certqr = "<H2>Certificate QR</H2>" + url2svg.GetStringSVG(p)
-		}
-		opt.WriteString(pre)
-		opt.WriteString(name)
-		opt.WriteString(html.EscapeString(p))
-		opt.WriteString("\n\t<H2>Certificate Status</H2><br>\n")
-		opt.WriteString(endPre)
-		opt.WriteString(certinfo.Decode(p, certReportHTML))
-		opt.WriteString(pre)
-		opt.WriteString(certqr)
-		opt.WriteString(endPre)
-	case false:
-		opt.WriteString(preCSS)
-		opt.WriteString(name)
-		opt.WriteString(syntaxhl(p))
-		opt.WriteString(urls(p, true, true))
-		opt.WriteString(endPre)
-	}
-	var s strings.Builder
+func (c *Config) getMagicHTML(s *pageWriter, key, ts string, t *pasteText) {
 	s.WriteString(c.i.head3)
 	s.WriteString(body)
 	s.WriteString(i2)
 	s.WriteString(c.i.banner)
-	s.WriteString(button(key, ts))
-	s.WriteString(opt.String())
+	s.WriteString(c.button(key, ts))
+	head, _ := t.r.Peek(33)
+	switch {
+	case !t.text:
+		s.WriteString(pre)
+		s.WriteString(pasteName(key, t.size))
+		s.WriteString(_binary)
+		s.WriteString(endPre)
+	case len(head) > 32 && (bytes.Contains(head[:32], []byte("BEGIN ")) || bytes.Contains(head[:32], []byte("ssh-"))):
+		s.WriteString(pre)
+		s.WriteString(pasteName(key, t.size))
+		p, err := io.ReadAll(io.LimitReader(t.r, _magicScan+1))
+		if err != nil && s.err == nil {
+			s.err = err
+		}
+		template.HTMLEscape(s, p)
+		if len(p) > _magicScan { // no certificate decode beyond the scan window
+			t.copyHTML(s)
+			s.WriteString(endPre)
+			break
+		}
+		s.WriteString("\n\t<H2>Certificate Status</H2><br>\n")
+		s.WriteString(endPre)
+		s.WriteString(certinfo.Decode(string(p), certReportHTML))
+		s.WriteString(pre)
+		if len(p) < 1024 {
+			s.WriteString("<H2>Certificate QR</H2>" + url2svg.GetStringSVG(string(p)))
+		}
+		s.WriteString(endPre)
+	default:
+		s.WriteString(preCSS)
+		s.WriteString(pasteName(key, t.size))
+		scan := &capBuffer{max: _magicScan}
+		syntaxhl(s, io.TeeReader(t.r, scan))
+		urls(s, scan.Bytes(), true, true)
+		s.WriteString(endPre)
+	}
 	s.WriteString(endBody)
-	return s.String(), nil
 }
 
-func getQRHTML(key string, urltarget *url.URL) (string, error) {
-	ts, isExpired := expired(key)
-	if isExpired {
-		return _empty, errExpired
-	}
-	u := "<br><br><br><p style=\"font-size:0.5em\"></style><strong>"
-	u += c.i.url + "/" + urltarget.String()[c.i.qrOFFSET:] + "</strong></p>"
-	var s strings.Builder
+func (c *Config) getQRHTML(s *pageWriter, key, ts, target string) {
 	s.WriteString(c.i.head3b)
 	s.WriteString(body)
 	s.WriteString(i2)
 	s.WriteString(c.i.banner)
-	s.WriteString(button(key, ts))
+	s.WriteString(c.button(key, ts))
 	s.WriteString("<br><br><br>")
-	s.WriteString(url2svg.GetSVG(urltarget))
-	s.WriteString(u)
+	s.WriteString(url2svg.GetStringSVG(target))
+	s.WriteString("<br><br><br><p style=\"font-size:0.5em\"></style><strong>")
+	s.WriteString(html.EscapeString(target) + "</strong></p>")
 	s.WriteString(endBody)
-	return s.String(), nil
 }
 
 // getDigagHTML provides the client connection analysis page
-func getDiagHTML(q *http.Request) (string, error) {
-	var opt strings.Builder
-	opt.Grow(4 * 1024)
-	opt.WriteString("\t<H2>client connection state</H2>\n")
-	opt.WriteString(tlsinfo.ReportTlsState(q.TLS, styleHTML))
-	opt.WriteString("\t<H2>complete raw request header</H2>\n")
-	opt.WriteString(getDiagHTMLHeader(q))
-	opt.WriteString("<H2><br><br><br>server timestamp [UTC] " + time.Now().Format(time.RFC3339) + "</H2>")
-	var s strings.Builder
-	s.Grow(8 * 1024)
+func (c *Config) getDiagHTML(s *pageWriter, q *http.Request) {
 	s.WriteString(c.i.head3)
 	s.WriteString(body)
 	s.WriteString(i3)
 	s.WriteString(c.i.banner)
 	s.WriteString(preCSS)
-	s.WriteString(opt.String())
+	s.WriteString("\t<H2>client connection state</H2>\n")
+	s.WriteString(tlsinfo.ReportTlsState(q.TLS, styleHTML))
+	s.WriteString(html.EscapeString(c.getDiagKEX(q)))
+	s.WriteString(html.EscapeString(getDiagForward(q)))
+	s.WriteString("\t<H2>client role</H2>\n")
+	s.WriteString(html.EscapeString(c.getDiagRole(q)))
+	status, state := c.getDiagRevocation(q)
+	s.WriteString("\t<H2>client certificate revocation</H2>\n")
+	s.WriteString(html.EscapeString(pad("Status") + " : " + status + _linefeed + pad("CRL State") + " : " + state + _linefeed))
+	s.WriteString("\t<H2>complete raw request header</H2>\n")
+	s.WriteString(getDiagHTMLHeader(q))
+	s.WriteString("<H2><br><br><br>server timestamp [UTC] " + time.Now().Format(time.RFC3339) + "</H2>")
 	s.WriteString(endPre)
 	s.WriteString(endBody)
-	return s.String(), nil
 }
 
 // getDiagHTMLHeader provides the sorted raw header summary
@@ -191,23 +276,39 @@ func getDiagHTMLHeader(q *http.Request) string {
 }
 
 //
-// PLAINTEXT ["curl-mode"] Page Renderer Engine
+// PLAINTEXT Page Renderer Engine
 //
 
-func getPlainText(key string) (string, error) {
-	p, err := readPaste(key, false)
-	if err != nil {
-		return _empty, err
+func getPlainText(s *pageWriter, p io.Reader) {
+	if _, err := io.Copy(s, p); err != nil && s.err == nil {
+		s.err = err
 	}
-	return p, nil
 }
 
-func getDiagText(q *http.Request) (string, error) {
-	var s strings.Builder
-	s.Grow(4 * 1024)
+func (c *Config) getDiagText(s *pageWriter, q *http.Request) {
 	s.WriteString(tlsinfo.ReportTlsState(q.TLS, styleText) + _linefeed)
+	s.WriteString(c.getDiagKEX(q))
+	s.WriteString(getDiagForward(q))
+	s.WriteString(c.getDiagRole(q))
+	status, state := c.getDiagRevocation(q)
+	s.WriteString(pad("Revocation") + " : " + status + _linefeed + pad("CRL State") + " : " + state + _linefeed + _linefeed)
 	s.WriteString(getDiagTextHeader(q) + _linefeed)
-	return s.String(), nil
+}
+
+// getDiagRole effective client role [admin: plus server state]
+func (c *Config) getDiagRole(q *http.Request) string {
+	role := c.requestRole(q)
+	out := pad("Role") + " : " + role.String() + _linefeed
+	if role.has(RoleAdmin) {
+		out += pad("Store Entries") + " : " + itoa(c.storeEntries()) + _linefeed
+		out += pad("Rate Limited Clients") + " : " + itoa(c.i.rlCreate.clients()+c.i.rlRead.clients()+c.i.rlDiag.clients()) + _linefeed
+	}
+	return out
+}
+
+func getQRText(s *pageWriter, target string) {
+	s.WriteString(url2svg.GetStringText(target))
+	s.WriteString(target + _linefeed)
 }
 
 func getDiagTextHeader(q *http.Request) string {
@@ -226,35 +327,154 @@ func getDiagTextHeader(q *http.Request) string {
 	return s.String()
 }
 
+//
+// JSON Page Renderer Engine
+//
+
+// qrTarget ...
+type qrTarget struct {
+	URL string `json:"url"`
+}
+
+// diagReport client connection state
+type diagReport struct {
+	TLS    *diagTLS            `json:"tls,omitempty"`
+	Remote string              `json:"remote"`
+	Proto  string              `json:"proto"`
+	Fwd    *forward            `json:"forwarded,omitempty"` // trusted proxy details
+	Role   string              `json:"role"`                // effective client role
+	Server *diagServer         `json:"server,omitempty"`    // server state [admin only]
+	Revoke *diagRevocation     `json:"revocation"`
+	Header map[string][]string `json:"header"`
+	Time   string              `json:"time"` // server timestamp [UTC]
+}
+
+// diagRevocation ...
+type diagRevocation struct {
+	Status string `json:"status"` // client certificate revocation status
+	CRL    string `json:"crl"`    // crl state
+}
+
+// diagServer ...
+type diagServer struct {
+	StoreEntries int `json:"store_entries"`
+	RateClients  int `json:"rate_limited_clients"`
+}
+
+// diagTLS ...
+type diagTLS struct {
+	Version     string   `json:"version"`
+	CipherSuite string   `json:"cipher_suite"`
+	Group       string   `json:"group"`   // negotiated key exchange group
+	Profile     string   `json:"profile"` // server tls profile
+	ALPN        string   `json:"alpn,omitempty"`
+	ServerName  string   `json:"server_name,omitempty"`
+	Resumed     bool     `json:"resumed"`
+	PeerCerts   []string `json:"peer_certificates,omitempty"` // subject [leaf first]
+}
+
+func (c *Config) getDiagJSON(q *http.Request) *diagReport {
+	role := c.requestRole(q)
+	status, state := c.getDiagRevocation(q)
+	d := &diagReport{Revoke: &diagRevocation{Status: status, CRL: state}, Remote: q.RemoteAddr, Proto: q.Proto, Fwd: forwardedBy(q), Role: role.String(), Header: q.Header, Time: time.Now().UTC().Format(time.RFC3339)}
+	if role.has(RoleAdmin) {
+		d.Server = &diagServer{StoreEntries: c.storeEntries(), RateClients: c.i.rlCreate.clients() + c.i.rlRead.clients() + c.i.rlDiag.clients()}
+	}
+	if q.TLS != nil {
+		d.TLS = &diagTLS{
+			Version:     tls.VersionName(q.TLS.Version),
+			CipherSuite: tls.CipherSuiteName(q.TLS.CipherSuite),
+			Group:       q.TLS.CurveID.String(),
+			Profile:     c.TLS.String(),
+			ALPN:        q.TLS.NegotiatedProtocol,
+			ServerName:  q.TLS.ServerName,
+			Resumed:     q.TLS.DidResume,
+		}
+		for _, cert := range q.TLS.PeerCertificates {
+			d.TLS.PeerCerts = append(d.TLS.PeerCerts, cert.Subject.String())
+		}
+	}
+	return d
+}
+
+//
+// STREAMING PAGE WRITER
+//
+
+// pageWriter sticky error writer, renderer write calls stay as terse as on a strings.Builder
+type pageWriter struct {
+	w   io.Writer
+	err error
+}
+
+// Write ...
+func (s *pageWriter) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	var n int
+	n, s.err = s.w.Write(p)
+	return n, s.err
+}
+
+// WriteString ...
+func (s *pageWriter) WriteString(in string) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	var n int
+	n, s.err = io.WriteString(s.w, in)
+	return n, s.err
+}
+
+// writePage streams the page renderer output via [escape|highlight] -> transport compression -> client
+func (c *Config) writePage(r http.ResponseWriter, q *http.Request, size int, render func(s *pageWriter)) error {
+	w := compress.NewTransportWriter(r, q, size, c.Tpolicy)
+	s := &pageWriter{w: w}
+	render(s)
+	if err := w.Close(); s.err == nil {
+		s.err = err
+	}
+	return s.err
+}
+
 //
 // SHARED BACKENDS
 //
 
 // syntaxhl wrapper
-func syntaxhl(in string) string {
-	p, err := syntax.AsHTML([]byte(in), syntax.OrderedList())
-	if err != nil {
+func syntaxhl(s *pageWriter, in io.Reader) {
+	if err := syntax.WriteHTML(s, in, syntax.OrderedList()); err != nil {
 		logsec.LogErr <- err.Error() // blocks in case of global [err rate limit]
-		return html.EscapeString(in)
 	}
-	return string(p)
 }
 
-// button shared button head
-func button(key, ts string) string {
+// pasteName shared paste name and size header line
+func pasteName(key string, size int) string {
+	var name string
+	sp := strings.Split(key, "@")
+	if len(sp) == 3 {
+		name = sp[2] + _space
+	}
+	return html.EscapeString(name) + "[Size:" + hruIEC(uint64(size), "byte") + "]\n\n"
+}
+
+// button shared button head [key: user chosen paste name, path and attribute escaped]
+func (c *Config) button(key, ts string) string {
 	var s strings.Builder
-	s.WriteString(href + _plain[:c.i.plainOFFSET] + key + "\">" + bu + clip + " PLAIN TEXT" + bue + "</a>")
-	s.WriteString(href + _magic[:c.i.magicOFFSET] + key + "\">" + bu + code + " MAGIC" + bue + "</a>")
+	key = html.EscapeString(url.PathEscape(key))
+	s.WriteString(href + c.i.base + _plain + key + "\">" + bu + clip + " PLAIN TEXT" + bue + "</a>")
+	s.WriteString(href + c.i.base + _magic + key + "\">" + bu + code + " MAGIC" + bue + "</a>")
 	s.WriteString(bu + clock + " EXPIRE: " + ts + bue)
-	s.WriteString(href + _download[:c.i.downloadOFFSET] + key + "\">" + bu + download + " DOWNLOAD" + bue + "</a>")
-	s.WriteString(href + _qr[:c.i.qrOFFSET] + key + "\">" + bu + link + " QR" + bue + "</a>")
+	s.WriteString(href + c.i.base + _download + key + "\">" + bu + download + " DOWNLOAD" + bue + "</a>")
+	s.WriteString(href + c.i.base + _qr + key + "\">" + bu + link + " QR" + bue + "</a>")
 	return s.String()
 }
 
 func expired(key string) (string, bool) {
 	ex := "NEVER"
 	isExpired := false
-	if key[:1] == "X" {
+	if strings.HasPrefix(key, "X") {
 		if ts, _, ok := strings.Cut(key[1:], "@"); ok {
 			x, err := atoi(ts)
 			if err != nil {
@@ -275,15 +495,14 @@ func expired(key string) (string, bool) {
 	return ex, isExpired
 }
 
-func urls(in string, head, css bool) string {
-	var s strings.Builder
+func urls(s *pageWriter, in []byte, head, css bool) {
 	parse := xurls.Strict()
-	array := parse.FindAllString(in, -1)
+	array := parse.FindAll(in, -1)
 	uMap := make(map[string]bool)
 	for _, v := range array {
-		uMap[v] = true
+		uMap[string(v)] = true
 	}
-	uniq := make([]string, len(uMap))
+	uniq := make([]string, 0, len(uMap))
 	for k := range uMap {
 		uniq = append(uniq, k)
 	}
@@ -336,7 +555,6 @@ func urls(in string, head, css bool) string {
 		default:
 		}
 	}
-	return s.String()
 }
 
 //
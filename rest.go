@@ -0,0 +1,273 @@
+package npad
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"paepcke.de/logsec"
+)
+
+//
+// VERSIONED JSON REST API [/api/v1/pastes]
+//
+
+const (
+	_json        = "application/json"
+	_apiMaxBody  = 16 * 1024 * 1024 // largest retention tier [10MB] base64 encoded plus json framing
+	_apiDefault  = "8h"
+	_apiBase64   = "base64"
+	_apiNotFound = "not_found"
+	_apiDelete   = "X-Delete-Token"
+)
+
+// apiNew create request
+type apiNew struct {
+	Content  string   `json:"content"`  // paste content
+	Encoding string   `json:"encoding"` // content encoding [<empty>|utf8|base64]
+	Name     string   `json:"name"`     // optional paste name [max:32]
+	Expiry   string   `json:"expiry"`   // retention tier [20m|8h|14d|never] [default: 8h]
+	ACL      []string `json:"acl"`      // optional private paste access list [me|cn:<name>|email:<addr>|sha256:<fingerprint>]
+}
+
+// apiPaste paste metadata [and content]
+type apiPaste struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name,omitempty"`
+	Expires  string   `json:"expires"`            // RFC3339 [never: permanent]
+	Size     int      `json:"size"`               // content size [bytes], create: submitted size
+	Type     string   `json:"type,omitempty"`     // sniffed content type
+	Encoding string   `json:"encoding,omitempty"` // content encoding [base64: non utf8 content]
+	Private  bool     `json:"private,omitempty"`  // access restricted to mtls client identities
+	Content  *string  `json:"content,omitempty"`
+	URLs     *apiURLs `json:"urls,omitempty"`
+	Delete   string   `json:"delete_token,omitempty"` // create only: capability for DELETE [header: X-Delete-Token]
+}
+
+// apiURLs ...
+type apiURLs struct {
+	Plain    string `json:"plain"`
+	Magic    string `json:"magic"`
+	Download string `json:"download"`
+	QR       string `json:"qr"`
+}
+
+// apiError ...
+type apiError struct {
+	Error apiErrorBody `json:"error"`
+}
+
+// apiErrorBody structured error [code: stable machine readable, message: human readable]
+type apiErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// getAPIHandler json rest api
+func (c *Config) getAPIHandler() http.Handler {
+	h := func(r http.ResponseWriter, q *http.Request) {
+		id := strings.TrimPrefix(q.URL.Path[c.i.apiOFFSET:], "/")
+		switch {
+		case id == _empty && q.Method == http.MethodPost:
+			c.apiCreate(r, q)
+		case id != _empty && q.Method == http.MethodGet:
+			c.apiRead(r, q, id)
+		case id != _empty && q.Method == http.MethodDelete:
+			c.apiDelete(r, q, id)
+		case id == _empty:
+			r.Header().Set("Allow", http.MethodPost)
+			apiFail(r, http.StatusMethodNotAllowed, "method_not_allowed", "["+q.Method+"] [allowed:POST]")
+		default:
+			r.Header().Set("Allow", http.MethodGet+", "+http.MethodDelete)
+			apiFail(r, http.StatusMethodNotAllowed, "method_not_allowed", "["+q.Method+"] [allowed:GET|DELETE]")
+		}
+	}
+	return http.HandlerFunc(h)
+}
+
+// apiCreate ...
+func (c *Config) apiCreate(r http.ResponseWriter, q *http.Request) {
+	if q.ContentLength > _apiMaxBody {
+		apiFail(r, http.StatusRequestEntityTooLarge, "too_large", "request body exceeds ["+itoa(_apiMaxBody)+"] bytes")
+		return
+	}
+	var n apiNew
+	d := json.NewDecoder(http.MaxBytesReader(r, q.Body, _apiMaxBody))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&n); err != nil {
+		var m *http.MaxBytesError
+		if errors.As(err, &m) {
+			apiFail(r, http.StatusRequestEntityTooLarge, "too_large", "request body exceeds ["+itoa(_apiMaxBody)+"] bytes")
+			return
+		}
+		apiFail(r, http.StatusBadRequest, "bad_request", "invalid json body ["+err.Error()+"]")
+		return
+	}
+	content := []byte(n.Content)
+	switch n.Encoding {
+	case _empty, "utf8":
+	case _apiBase64:
+		b, err := base64.StdEncoding.DecodeString(n.Content)
+		if err != nil {
+			apiFail(r, http.StatusBadRequest, "bad_encoding", "content is not valid base64 ["+err.Error()+"]")
+			return
+		}
+		content = b
+	default:
+		apiFail(r, http.StatusBadRequest, "bad_encoding", "unsupported encoding ["+n.Encoding+"] [allowed:utf8|base64]")
+		return
+	}
+	if n.Expiry == _empty {
+		n.Expiry = _apiDefault
+	}
+	expire, ok := parseExpiry(n.Expiry)
+	if !ok {
+		apiFail(r, http.StatusBadRequest, "invalid_expiry", "unsupported expiry ["+n.Expiry+"] [allowed:20m|8h|14d|never]")
+		return
+	}
+	if !c.checkRole(r, q, uploadRole(expire)) {
+		return
+	}
+	acl, err := c.parseACL(strings.Join(n.ACL, ","), q)
+	if err != nil {
+		apiFail(r, http.StatusBadRequest, "invalid_acl", err.Error())
+		return
+	}
+	token := hex.EncodeToString(genRand()[:32])
+	key, err := c.savePaste(content, expire, pasteMeta{Name: n.Name, ACL: acl, Delete: deleteHash(token)})
+	if err != nil {
+		logsec.LogInfo <- "[api] [new] [save paste] " + err.Error()
+		switch {
+		case errors.Is(err, errTooLarge):
+			apiFail(r, http.StatusRequestEntityTooLarge, "too_large", err.Error())
+		default:
+			apiFail(r, http.StatusInternalServerError, "internal", "unable to store paste")
+		}
+		return
+	}
+	logsec.LogInfo <- "[api] [new] " + key
+	m := apiMeta(key, len(content))
+	m.Type = http.DetectContentType(content)
+	m.Private = acl != nil
+	m.Delete = token
+	m.URLs = &apiURLs{
+		Plain:    c.i.url + _plain + url.PathEscape(key),
+		Magic:    c.i.url + _magic + url.PathEscape(key),
+		Download: c.i.url + _download + url.PathEscape(key),
+		QR:       c.i.url + _qr + url.PathEscape(key),
+	}
+	r.Header().Set("Location", c.i.base+_api+"/"+url.PathEscape(key))
+	c.apiWrite(r, q, http.StatusCreated, m)
+}
+
+// apiRead ...
+func (c *Config) apiRead(r http.ResponseWriter, q *http.Request, id string) {
+	pr, err := c.openPlain(q, id)
+	var p []byte
+	if err == nil {
+		p, err = pr.read(false)
+		pr.Close()
+	}
+	if err != nil {
+		logsec.LogErr <- "[api] [read] [" + err.Error() + "]"
+		apiFail(r, http.StatusNotFound, _apiNotFound, "paste not found")
+		return
+	}
+	m := apiMeta(id, len(p))
+	if pr.meta.Name != _empty {
+		m.Name = pr.meta.Name
+	}
+	m.Type = pr.meta.Type
+	m.Private = pr.meta.ACL != nil
+	content := string(p)
+	if !utf8.Valid(p) {
+		m.Encoding = _apiBase64
+		content = base64.StdEncoding.EncodeToString(p)
+	}
+	m.Content = &content
+	c.apiWrite(r, q, http.StatusOK, m)
+}
+
+// apiDelete ...
+func (c *Config) apiDelete(r http.ResponseWriter, q *http.Request, id string) {
+	p, err := c.openPlain(q, id)
+	if err != nil {
+		logsec.LogErr <- "[api] [delete] [" + err.Error() + "]"
+		apiFail(r, http.StatusNotFound, _apiNotFound, "paste not found")
+		return
+	}
+	stored := p.meta.Delete
+	p.Close()
+	token := q.Header.Get(_apiDelete)
+	if stored == _empty || token == _empty || subtle.ConstantTimeCompare([]byte(deleteHash(token)), []byte(stored)) != 1 {
+		logsec.LogInfo <- "[api] [delete] [invalid delete token] " + id
+		apiFail(r, http.StatusForbidden, "forbidden", "valid delete token required [header: "+_apiDelete+"]")
+		return
+	}
+	if err = c.deletePaste(id); err != nil {
+		logsec.LogErr <- "[api] [delete] [" + err.Error() + "]"
+		apiFail(r, http.StatusNotFound, _apiNotFound, "paste not found")
+		return
+	}
+	logsec.LogInfo <- "[api] [delete] " + id
+	r.WriteHeader(http.StatusNoContent)
+}
+
+// deleteHash stored form of a delete token [sha256 hex]
+func deleteHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiMeta paste metadata from the url key
+func apiMeta(key string, size int) *apiPaste {
+	m := &apiPaste{ID: key, Size: size, Expires: "never"}
+	sp := strings.Split(key, "@")
+	if len(sp) == 3 {
+		m.Name = sp[2]
+	}
+	if key[:1] == "X" {
+		if ts, err := atoi64(sp[0][1:]); err == nil {
+			m.Expires = time.Unix(ts, 0).UTC().Format(time.RFC3339)
+		}
+	}
+	return m
+}
+
+// apiWrite ...
+func (c *Config) apiWrite(r http.ResponseWriter, q *http.Request, status int, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		apiFail(r, http.StatusInternalServerError, "internal", "unable to encode response")
+		return
+	}
+	r.Header().Set(_ctype, _json)
+	r.Header().Set(_title, c.App)
+	if status != http.StatusOK {
+		r.WriteHeader(status)
+		if _, err = r.Write(b); err != nil {
+			logsec.LogErr <- "[api] [out] [" + err.Error() + "]"
+		}
+		return
+	}
+	if err = c.writePage(r, q, len(b), func(s *pageWriter) { s.Write(b) }); err != nil {
+		logsec.LogErr <- "[api] [out] [" + err.Error() + "]"
+	}
+}
+
+// apiFail ...
+func apiFail(r http.ResponseWriter, status int, code, msg string) {
+	b, _ := json.Marshal(apiError{Error: apiErrorBody{Code: code, Message: msg}})
+	r.Header().Set(_ctype, _json)
+	r.Header().Set("X-Content-Type-Options", "nosniff")
+	r.WriteHeader(status)
+	_, _ = r.Write(append(b, '\n'))
+}
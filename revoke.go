@@ -0,0 +1,301 @@
+package npad
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"paepcke.de/logsec"
+)
+
+//
+// CLIENT CERTIFICATE REVOCATION [local crl files|local ocsp responder stand-in]
+//
+
+const _crlReload = time.Hour
+
+// crlSet verified crl of one issuer
+type crlSet struct {
+	issuer  *x509.Certificate
+	file    string
+	this    time.Time
+	next    time.Time
+	revoked map[string]time.Time // serial [hex] -> revocation time
+}
+
+// revocation client certificate revocation state
+type revocation struct {
+	cas    []*x509.Certificate // client ca certificates [crl signer]
+	files  []*pinnedFile
+	ocsp   *os.Root
+	mu     sync.RWMutex
+	crls   map[string]*crlSet // issuer public key -> crl
+	strict bool               // fail-closed
+	loaded time.Time
+	err    error // last reload error
+}
+
+// newRevocation pins the crl files and the ocsp directory before the priv drop, loads all crls [nil: disabled]
+func (c *Config) newRevocation(cas []*x509.Certificate) (*revocation, error) {
+	if len(c.CRLfiles) == 0 && c.OCSPdir == _empty {
+		return nil, nil
+	}
+	if len(cas) == 0 {
+		return nil, errors.New("revocation checks need a client ca [CAclient]")
+	}
+	rv := &revocation{cas: cas, crls: make(map[string]*crlSet), strict: c.CRLstrict}
+	for _, name := range c.CRLfiles {
+		f, err := pinFile(name)
+		if err != nil {
+			return nil, err
+		}
+		rv.files = append(rv.files, f)
+	}
+	if c.OCSPdir != _empty {
+		root, err := os.OpenRoot(c.OCSPdir)
+		if err != nil {
+			return nil, err
+		}
+		rv.ocsp = root
+	}
+	if err := rv.reload(); err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// reload reads and verifies all crl files, a failing file keeps its previous crl
+func (rv *revocation) reload() error {
+	crls := make(map[string]*crlSet, len(rv.files))
+	var errs []error
+	for _, f := range rv.files {
+		set, err := rv.loadCRL(f)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("[crl] [%s] [%w]", f.path, err))
+			rv.mu.RLock()
+			for k, old := range rv.crls {
+				if old.file == f.path {
+					crls[k] = old
+				}
+			}
+			rv.mu.RUnlock()
+			continue
+		}
+		k := string(set.issuer.RawSubjectPublicKeyInfo)
+		if old, ok := crls[k]; !ok || set.this.After(old.this) {
+			crls[k] = set
+		}
+	}
+	err := errors.Join(errs...)
+	rv.mu.Lock()
+	rv.crls, rv.loaded, rv.err = crls, time.Now(), err
+	rv.mu.Unlock()
+	return err
+}
+
+// loadCRL parses a [pem|der] crl and verifies its signature against the client cas
+func (rv *revocation) loadCRL(f *pinnedFile) (*crlSet, error) {
+	data, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	rv.mu.RLock()
+	cas := rv.cas
+	rv.mu.RUnlock()
+	crl, ca, err := parseCRL(data, cas)
+	if err != nil {
+		return nil, err
+	}
+	set := &crlSet{issuer: ca, file: f.path, this: crl.ThisUpdate, next: crl.NextUpdate, revoked: make(map[string]time.Time, len(crl.RevokedCertificateEntries))}
+	for _, e := range crl.RevokedCertificateEntries {
+		set.revoked[e.SerialNumber.Text(16)] = e.RevocationTime
+	}
+	return set, nil
+}
+
+// parseCRL parses a [pem|der] crl, returns the signing client ca
+func parseCRL(data []byte, cas []*x509.Certificate) (*x509.RevocationList, *x509.Certificate, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, ca := range cas {
+		if bytes.Equal(ca.RawSubject, crl.RawIssuer) && crl.CheckSignatureFrom(ca) == nil {
+			return crl, ca, nil
+		}
+	}
+	return nil, nil, errors.New("crl not signed by any client ca")
+}
+
+// setCAs replaces the client cas after a client ca reload, the next crl reload verifies against them
+func (rv *revocation) setCAs(cas []*x509.Certificate) {
+	rv.mu.Lock()
+	rv.cas = cas
+	rv.mu.Unlock()
+}
+
+// verifyRevocation tls VerifyPeerCertificate hook [revocation disabled: pass]
+func (c *Config) verifyRevocation(raw [][]byte, chains [][]*x509.Certificate) error {
+	if rv := c.i.revoke; rv != nil {
+		return rv.verify(raw, chains)
+	}
+	return nil
+}
+
+// verify tls VerifyPeerCertificate hook, any verified chain without revoked certificate passes
+func (rv *revocation) verify(_ [][]byte, chains [][]*x509.Certificate) error {
+	var err error
+	for _, chain := range chains {
+		if _, err = rv.checkChain(chain, time.Now()); err == nil {
+			return nil
+		}
+	}
+	if err != nil {
+		logsec.LogErr <- "[revocation] " + err.Error()
+	}
+	return err
+}
+
+// checkChain checks every chain certificate except the root against its issuer
+func (rv *revocation) checkChain(chain []*x509.Certificate, now time.Time) (string, error) {
+	var info []string
+	for i := 0; i+1 < len(chain); i++ {
+		s, err := rv.check(chain[i], chain[i+1], now)
+		if err != nil {
+			return _empty, err
+		}
+		info = append(info, s)
+	}
+	return strings.Join(info, " "), nil
+}
+
+// check returns the revocation status of cert, strict mode fails without current crl or good ocsp response
+func (rv *revocation) check(cert, issuer *x509.Certificate, now time.Time) (string, error) {
+	id := "[" + cert.Subject.CommonName + "] [serial:" + cert.SerialNumber.Text(16) + "]"
+	rv.mu.RLock()
+	set := rv.crls[string(issuer.RawSubjectPublicKeyInfo)]
+	rv.mu.RUnlock()
+	var info []string
+	covered := false
+	if set != nil {
+		if t, ok := set.revoked[cert.SerialNumber.Text(16)]; ok {
+			return _empty, errors.New(id + " [revoked:" + t.UTC().Format(time.RFC3339) + "] [crl]")
+		}
+		switch {
+		case !set.next.IsZero() && now.After(set.next):
+			info = append(info, "[crl:outdated since "+set.next.UTC().Format(time.RFC3339)+"]")
+		default:
+			info = append(info, "[crl:not revoked]")
+			covered = true
+		}
+	}
+	if rv.ocsp != nil {
+		s, err := rv.checkOCSP(cert, issuer, now)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			info = append(info, "[ocsp:no response]")
+		case err != nil:
+			info = append(info, "[ocsp:"+err.Error()+"]")
+		case s.status == ocspRevoked:
+			return _empty, errors.New(id + " [revoked:" + s.revokedAt.UTC().Format(time.RFC3339) + "] [ocsp]")
+		case s.status == ocspGood:
+			info = append(info, "[ocsp:good]")
+			covered = true
+		default:
+			info = append(info, "[ocsp:unknown]")
+		}
+	}
+	if !covered && rv.strict {
+		return _empty, errors.New(id + " [no current revocation information] " + strings.Join(info, " "))
+	}
+	return id + " " + strings.Join(info, " "), nil
+}
+
+// checkOCSP reads the pre-fetched ocsp response [<serial hex>.der] of cert
+func (rv *revocation) checkOCSP(cert, issuer *x509.Certificate, now time.Time) (*ocspStatus, error) {
+	f, err := rv.ocsp.Open(cert.SerialNumber.Text(16) + ".der")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	der, err := io.ReadAll(io.LimitReader(f, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	return parseOCSP(der, cert, issuer, now)
+}
+
+// state crl summary
+func (rv *revocation) state() string {
+	rv.mu.RLock()
+	defer rv.mu.RUnlock()
+	var s []string
+	for _, set := range rv.crls {
+		s = append(s, "["+set.issuer.Subject.CommonName+"] [revoked:"+itoa(len(set.revoked))+"] [next update:"+set.next.UTC().Format(time.RFC3339)+"]")
+	}
+	out := "[crls:" + itoa(len(rv.crls)) + "] [loaded:" + rv.loaded.UTC().Format(time.RFC3339) + "] " + strings.Join(s, " ")
+	if rv.err != nil {
+		out += " [reload error:" + strings.ReplaceAll(rv.err.Error(), _linefeed, " ") + "]"
+	}
+	return out
+}
+
+// revocationAutoReload periodically reloads the crls
+func (c *Config) revocationAutoReload(ctx context.Context) {
+	rv := c.i.revoke
+	if rv == nil || len(rv.files) == 0 {
+		return
+	}
+	interval := c.CRLreload
+	if interval <= 0 {
+		interval = _crlReload
+	}
+	for sleep(ctx, interval) {
+		if err := rv.reload(); err != nil {
+			logsec.LogErr <- "[revocation] [reload] " + strings.ReplaceAll(err.Error(), _linefeed, " ")
+		}
+	}
+}
+
+// getDiagRevocation client certificate revocation status and crl state
+func (c *Config) getDiagRevocation(q *http.Request) (status, state string) {
+	rv := c.i.revoke
+	switch {
+	case rv == nil:
+		return "disabled", "disabled"
+	case q.TLS == nil || len(q.TLS.VerifiedChains) == 0:
+		return "no client certificate", rv.state()
+	}
+	status, err := rv.checkChain(q.TLS.VerifiedChains[0], time.Now())
+	if err != nil {
+		status = err.Error()
+	}
+	return status, rv.state()
+}
+
+// parseCerts ...
+func parseCerts(data []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			return certs
+		}
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil && block.Type == "CERTIFICATE" {
+			certs = append(certs, cert)
+		}
+	}
+}
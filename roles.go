@@ -0,0 +1,193 @@
+package npad
+
+import (
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"paepcke.de/logsec"
+)
+
+//
+// ROLE BASED AUTHORIZATION [client certificate attributes -> roles]
+//
+
+// Role permission set
+type Role uint8
+
+// roles
+const (
+	RoleRead      Role                                                = 1 << iota // view, download, qr, diag
+	RoleUpload                                                                    // create and delete pastes [retention tiers: 20min|8h|14days]
+	RolePermanent                                                                 // create permanent pastes [retention tier: never]
+	RoleAdmin                                                                     // all roles, server state on the diag page
+	RoleAll       = RoleRead | RoleUpload | RolePermanent | RoleAdmin             // unrestricted
+)
+
+// roleNoPolicy roles of every client without role policy [admin only via explicit rule]
+const roleNoPolicy = RoleRead | RoleUpload | RolePermanent
+
+// RoleRule grants roles to verified client certificates matching all set attributes [empty rule: any verified client]
+type RoleRule struct {
+	Issuer      string // issuer common name or full issuer dn
+	OU          string // subject organizational unit
+	SAN         string // subject alternative name [email|dns|uri]
+	Fingerprint string // certificate sha256 fingerprint [hex|colon separated hex]
+	Roles       Role   // granted roles
+}
+
+// RolePolicy maps client certificates to roles, roles of all matching rules add up
+type RolePolicy struct {
+	Rules     []RoleRule // certificate attribute rules
+	Default   Role       // roles of verified clients without matching rule
+	Anonymous Role       // roles of clients without verified certificate
+}
+
+// String ...
+func (r Role) String() string {
+	if r == 0 {
+		return "none"
+	}
+	var s []string
+	for _, x := range []struct {
+		role Role
+		name string
+	}{{RoleRead, "read"}, {RoleUpload, "upload"}, {RolePermanent, "permanent"}, {RoleAdmin, "admin"}} {
+		if r&x.role != 0 {
+			s = append(s, x.name)
+		}
+	}
+	return strings.Join(s, "|")
+}
+
+// has ...
+func (r Role) has(need Role) bool { return r&need == need }
+
+// matches ...
+func (rule *RoleRule) matches(leaf *x509.Certificate) bool {
+	if rule.Issuer != _empty && rule.Issuer != leaf.Issuer.CommonName && rule.Issuer != leaf.Issuer.String() {
+		return false
+	}
+	if rule.OU != _empty && !slices.Contains(leaf.Subject.OrganizationalUnit, rule.OU) {
+		return false
+	}
+	if rule.SAN != _empty && !hasSAN(leaf, rule.SAN) {
+		return false
+	}
+	if rule.Fingerprint != _empty && !strings.EqualFold(strings.ReplaceAll(rule.Fingerprint, ":", _empty), certFingerprint(leaf)) {
+		return false
+	}
+	return true
+}
+
+// hasSAN ...
+func hasSAN(leaf *x509.Certificate, san string) bool {
+	if slices.ContainsFunc(leaf.EmailAddresses, func(e string) bool { return strings.EqualFold(e, san) }) ||
+		slices.ContainsFunc(leaf.DNSNames, func(d string) bool { return strings.EqualFold(d, san) }) {
+		return true
+	}
+	return slices.ContainsFunc(leaf.URIs, func(u *url.URL) bool { return u.String() == san })
+}
+
+// roleOf returns the effective role of a verified client certificate [nil: anonymous]
+func (c *Config) roleOf(leaf *x509.Certificate) Role {
+	p := c.Roles
+	if p == nil {
+		return roleNoPolicy
+	}
+	if leaf == nil {
+		return p.Anonymous
+	}
+	var r Role
+	matched := false
+	for i := range p.Rules {
+		if p.Rules[i].matches(leaf) {
+			r |= p.Rules[i].Roles
+			matched = true
+		}
+	}
+	if !matched {
+		r = p.Default
+	}
+	if r.has(RoleAdmin) {
+		r = RoleAll
+	}
+	return r
+}
+
+// requestRole ...
+func (c *Config) requestRole(q *http.Request) Role { return c.roleOf(verifiedLeaf(q.TLS)) }
+
+// uploadRole returns the role needed to store a paste of the retention tier
+func uploadRole(expire int) Role {
+	if expire == 3 {
+		return RoleUpload | RolePermanent
+	}
+	return RoleUpload
+}
+
+// requireRole enforces the route role, uploads [POST|PUT] and deletes need the upload role
+func (c *Config) requireRole(next http.Handler) http.Handler {
+	h := func(r http.ResponseWriter, q *http.Request) {
+		need := RoleRead
+		switch q.Method {
+		case http.MethodPost, http.MethodPut, http.MethodDelete:
+			need = RoleUpload
+		}
+		if !c.checkRole(r, q, need) {
+			return
+		}
+		next.ServeHTTP(r, q)
+	}
+	return http.HandlerFunc(h)
+}
+
+// checkRole reports if the caller holds the role, otherwise sends 403
+func (c *Config) checkRole(r http.ResponseWriter, q *http.Request, need Role) bool {
+	role := c.requestRole(q)
+	if role.has(need) {
+		return true
+	}
+	reason := "role required: " + need.String() + "] [your role: " + role.String()
+	logsec.LogErr <- "[roles] [" + clientID(q) + "] [" + q.Method + "] [" + reason + "]"
+	if strings.HasPrefix(q.URL.Path, _api) {
+		apiFail(r, http.StatusForbidden, "forbidden", "["+reason+"]")
+		return false
+	}
+	forbidden(r, reason)
+	return false
+}
+
+// ncRole returns the role of a raw tcp upload client
+func (c *Config) ncRole(conn net.Conn) Role {
+	return c.roleOf(ncLeaf(conn))
+}
+
+// storeEntries number of stored pastes
+func (c *Config) storeEntries() int {
+	var n int
+	switch c.PermSTORE {
+	case true:
+		d, err := c.storeDir()
+		if err != nil {
+			return -1
+		}
+		for _, e := range d {
+			if !strings.HasSuffix(e.Name(), _meta) {
+				n++
+			}
+		}
+	case false:
+		c.i.storeMUTEX.RLock()
+		for k := range c.i.store {
+			if !strings.HasSuffix(k, _meta) {
+				n++
+			}
+		}
+		c.i.storeMUTEX.RUnlock()
+	}
+	return n
+}
@@ -1,9 +1,15 @@
 package npad
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha512"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"runtime"
 	"strconv"
@@ -19,130 +25,387 @@ import (
 // STORAGE BACKENDS
 //
 
+// _meta paste metadata store key suffix
+const _meta = ".meta"
+
+// _storeGC default store gc interval
+const _storeGC = 20 * time.Minute
+
+var (
+	errExpire   = errors.New("undefined store expire mode")
+	errTooLarge = errors.New("input to large")
+)
+
+// expiryTiers maps expiry names to store retention tiers
+var expiryTiers = map[string]int{"20m": 0, "8h": 1, "14d": 2, "never": 3}
+
+// parseExpiry accepts expiry names [20m|8h|14d|never] and raw retention tiers [0-3]
+func parseExpiry(in string) (int, bool) {
+	if t, ok := expiryTiers[in]; ok {
+		return t, true
+	}
+	t, err := atoi(in)
+	return t, err == nil && t >= 0 && t <= 3
+}
+
+// tierMax upload size limit per retention tier [0:20min|1:8h|2:14days|3:never]
+var tierMax = [...]int{10 * 1024 * 1024, 8 * 1024 * 1024, 2 * 1024 * 1024, 200 * 1024}
+
+// tooLarge ...
+func tooLarge(allowed string) error {
+	return fmt.Errorf("%w - [allowed:%s]", errTooLarge, allowed)
+}
+
 //
 // Storage IO
 //
 
+// pasteMeta paste metadata, stored [encrypted] next to the paste
+type pasteMeta struct {
+	Name   string    `json:"n,omitempty"` // original [file] name
+	Type   string    `json:"t,omitempty"` // sniffed content type
+	Size   int       `json:"s"`           // content size [bytes]
+	ACL    *pasteACL `json:"a,omitempty"` // private paste access list [nil: public]
+	Delete string    `json:"d,omitempty"` // api delete token sha256 [hex] [empty: not deletable via api]
+}
+
 // savePaste ...
-func savePaste(in, name string, expire int) (string, error) {
-	if name != "" {
-		name = "@" + name
+func (c *Config) savePaste(in []byte, expire int, m pasteMeta) (string, error) {
+	var name string
+	if m.Name != "" {
+		name = "@" + strings.NewReplacer("@", "_", "/", "_", "\\", "_").Replace(m.Name)
 	}
 	if len(name) > 32 {
 		name = name[:32]
 	}
+	if strings.HasSuffix(name, _meta) {
+		name += "_"
+	}
+	if len(m.Name) > 255 {
+		m.Name = m.Name[:255]
+	}
+	m.Type = http.DetectContentType(in)
+	m.Size = len(in)
 	var prefix string
 	switch expire {
 	case 0:
-		if len(in) > 10*1024*1024 {
-			return "", errors.New("input to large - [allowed:20min|10MB]")
+		if len(in) > tierMax[0] {
+			return "", tooLarge("20min|10MB")
 		}
 		prefix = "X" + itoa64((time.Now().Add(20 * time.Minute)).Unix())
 	case 1:
-		if len(in) > 8*1024*1024 {
-			return "", errors.New("input to large - [allowed:8h|8MB]")
+		if len(in) > tierMax[1] {
+			return "", tooLarge("8h|8MB")
 		}
 		prefix = "X" + itoa64((time.Now().Add(8 * time.Hour)).Unix())
 	case 2:
-		if len(in) > 2*1024*1024 {
-			return "", errors.New("input to large - [allowed:14days|2MB]")
+		if len(in) > tierMax[2] {
+			return "", tooLarge("14days|2MB")
 		}
 		prefix = "X" + itoa64((time.Now().Add(14 * 24 * time.Hour)).Unix())
 	case 3:
-		if len(in) > 200*1024 {
-			return "", errors.New("input to large - [allowed:256k]")
+		if len(in) > tierMax[3] {
+			return "", tooLarge("256k")
 		}
 		prefix = "N" + itoa64((time.Now()).Unix())
 	default:
-		panic("undefined store expire mode - unable to continue")
-	}
-	var err error
-	var data []byte
-	data = []byte(in)
-	switch c.Clevel {
-	case 0:
-	default:
-		data = compress.Compress(c.Calgo, c.Clevel, data)
+		return "", errExpire
 	}
-	k := sha512.Sum512(data)
+	k := sha512.Sum512(in)
 	key := sha512.Sum384(append(append(k[:], strconv.FormatInt((time.Now().UnixNano()), 10)...), genRand()...))
 	keyid := base64.RawURLEncoding.EncodeToString(key[:])
 	file := prefix + "@" + keyid[:16] + name
-	var url string
-	switch c.Ealgo {
-	case "":
-		url = file
-	default:
-		rawkey := sha512.Sum512_256(key[16:])
-		nonce := sha512.Sum512_224(append([]byte(prefix), key[:16]...))
-		if data, err = encrypt.Encrypt(c.Ealgo, rawkey, nonce[:16], data); err != nil {
-			return "", err
-		}
+	url := file
+	if c.Ealgo != "" {
 		url = prefix + "@" + keyid + name
 	}
+	meta, err := c.sealMeta(prefix, key[:], m)
+	if err != nil {
+		return "", err
+	}
 	if c.PermSTORE {
-		return url, os.WriteFile(file, data, 0o660)
+		f, err := c.i.fs.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o660)
+		if err != nil {
+			return "", err
+		}
+		if err = c.sealPaste(f, prefix, key[:], in); err == nil {
+			err = f.Close()
+		}
+		if err == nil {
+			err = c.i.fs.WriteFile(file+_meta, meta, 0o660)
+		}
+		if err != nil {
+			f.Close()
+			c.i.fs.Remove(file)
+			return "", err
+		}
+		return url, nil
+	}
+	var buf bytes.Buffer
+	if err := c.sealPaste(&buf, prefix, key[:], in); err != nil {
+		return "", err
 	}
 	c.i.storeMUTEX.Lock()
-	c.i.store[file] = data
+	c.i.store[file] = buf.Bytes()
+	c.i.store[file+_meta] = meta
 	c.i.storeMUTEX.Unlock()
 	return url, nil
 }
 
-// readPaste ...
-func readPaste(key string, raw bool) (string, error) {
-	var (
-		ok   bool
-		err  error
-		data []byte
-	)
-	var file string
-	k := strings.Split(key, "@")
-	switch len(k) {
-	case 2:
-		file = k[0] + "@" + k[1][:16]
-	case 3:
-		file = k[0] + "@" + k[1][:16] + "@" + k[2]
-	default:
-		return "", errors.New("[store] [url] [decode] [err]")
+// sealMeta ...
+func (c *Config) sealMeta(prefix string, key []byte, m pasteMeta) ([]byte, error) {
+	data, err := json.Marshal(m)
+	if err != nil || c.Ealgo == "" {
+		return data, err
+	}
+	rawkey, _ := pasteCipher(prefix, key)
+	return encrypt.Encrypt(c.Ealgo, rawkey, metaNonce(prefix, key), data)
+}
+
+// openMeta ...
+func (c *Config) openMeta(prefix string, key, data []byte) (pasteMeta, error) {
+	var m pasteMeta
+	var err error
+	if c.Ealgo != "" {
+		rawkey, _ := pasteCipher(prefix, key)
+		if data, err = encrypt.Decrypt(c.Ealgo, rawkey, metaNonce(prefix, key), data); err != nil {
+			return m, err
+		}
+	}
+	return m, json.Unmarshal(data, &m)
+}
+
+// metaNonce metadata nonce, domain separated from the paste nonce
+func metaNonce(prefix string, key []byte) []byte {
+	nonce := sha512.Sum512_224(append([]byte(_meta+prefix), key[:16]...))
+	return nonce[:16]
+}
+
+// sealPaste streams the paste via [compress] -> [chunked encrypt] into the store backend
+func (c *Config) sealPaste(w io.Writer, prefix string, key []byte, in []byte) error {
+	var e, z io.WriteCloser
+	var err error
+	if c.Ealgo != "" {
+		rawkey, nonce := pasteCipher(prefix, key)
+		if e, err = encrypt.NewStreamWriter(c.Ealgo, rawkey, nonce, w); err != nil {
+			return err
+		}
+		w = e
 	}
+	if c.Clevel > 0 {
+		if z, err = compress.NewWriter(c.Calgo, c.Clevel, w); err != nil {
+			return err
+		}
+		w = z
+	}
+	if _, err = w.Write(in); err != nil {
+		return err
+	}
+	if z != nil {
+		if err = z.Close(); err != nil {
+			return err
+		}
+	}
+	if e != nil {
+		return e.Close()
+	}
+	return nil
+}
+
+// pasteCipher derives the paste key and nonce from the url key part
+func pasteCipher(prefix string, key []byte) ([32]byte, []byte) {
+	rawkey := sha512.Sum512_256(key[16:])
+	nonce := sha512.Sum512_224(append([]byte(prefix), key[:16]...))
+	return rawkey, nonce[:16]
+}
+
+// pasteReader decrypted stored paste representation [still compressed] with random access
+type pasteReader struct {
+	*io.SectionReader
+	c     *Config
+	meta  pasteMeta
+	f     *os.File
+	file  string      // store backend key
+	store io.ReaderAt // stored [cipher]text
+	size  int64       // stored [cipher]text size
+}
+
+// etag strong validator derived from the stored [cipher]text hash, pastes are immutable, cached per store key
+func (p *pasteReader) etag() (string, error) {
+	if e, ok := p.c.i.etags.Load(p.file); ok {
+		return e.(string), nil
+	}
+	h := sha512.New512_256()
+	if _, err := io.Copy(h, io.NewSectionReader(p.store, 0, p.size)); err != nil {
+		return "", err
+	}
+	e := "\"" + base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:18]) + "\""
+	p.c.i.etags.Store(p.file, e)
+	return e, nil
+}
+
+// Close releases the store backend
+func (p *pasteReader) Close() error {
+	if p.f != nil {
+		return p.f.Close()
+	}
+	return nil
+}
+
+// content returns the stored representation [raw] or the decompressed paste stream
+func (p *pasteReader) content(raw bool) (io.ReadCloser, error) {
+	if raw || p.c.Clevel == 0 {
+		return io.NopCloser(p.SectionReader), nil
+	}
+	return compress.NewReader(p.c.Calgo, p.SectionReader)
+}
+
+// openPaste ...
+func (c *Config) openPaste(key string) (*pasteReader, error) {
+	file, k, err := pasteFile(key)
+	if err != nil {
+		return nil, err
+	}
+	p := &pasteReader{c: c, file: file}
+	var r io.ReaderAt
+	var size int64
 	switch c.PermSTORE {
 	case true:
-		if data, err = os.ReadFile(file); err != nil {
-			return "", err
+		f, err := c.i.fs.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
 		}
+		p.f, r, size = f, f, fi.Size()
 	case false:
 		c.i.storeMUTEX.RLock()
-		if data, ok = c.i.store[file]; !ok {
-			c.i.storeMUTEX.RUnlock()
-			return "", errors.New("key req miss [map] [" + file + "]")
-		}
+		data, ok := c.i.store[file]
 		c.i.storeMUTEX.RUnlock()
+		if !ok {
+			return nil, errors.New("key req miss [map] [" + file + "]")
+		}
+		r, size = bytes.NewReader(data), int64(len(data))
 	}
-	switch c.Ealgo {
-	case "":
-	default:
-		key, err := base64.RawURLEncoding.DecodeString(k[1])
-		if err != nil {
-			return "", errors.New("decrypt url base64 decoder " + err.Error())
+	p.store, p.size = r, size
+	var secret []byte
+	if c.Ealgo != "" {
+		if secret, err = base64.RawURLEncoding.DecodeString(k[1]); err != nil {
+			p.Close()
+			return nil, errors.New("decrypt url base64 decoder " + err.Error())
 		}
-		if len(key) != 48 {
-			return "", errors.New("decrypt url key invalid")
+		if len(secret) != 48 {
+			p.Close()
+			return nil, errors.New("decrypt url key invalid")
 		}
-		rawkey := sha512.Sum512_256(key[16:])
-		nonce := sha512.Sum512_224(append([]byte(k[0]), key[:16]...))
-		if data, err = encrypt.Decrypt(c.Ealgo, rawkey, nonce[:16], data); err != nil {
-			return "", err
+		rawkey, nonce := pasteCipher(k[0], secret)
+		s, err := encrypt.NewStreamReader(c.Ealgo, rawkey, nonce, r, size)
+		switch {
+		case err == nil:
+			r, size = s, s.Size()
+		case !errors.Is(err, encrypt.ErrNoStream):
+			p.Close()
+			return nil, err
+		default:
+			// legacy single shot sealed paste
+			data := make([]byte, size)
+			if _, err := r.ReadAt(data, 0); err != nil && err != io.EOF {
+				p.Close()
+				return nil, err
+			}
+			if data, err = encrypt.Decrypt(c.Ealgo, rawkey, nonce, data); err != nil {
+				p.Close()
+				return nil, err
+			}
+			r, size = bytes.NewReader(data), int64(len(data))
 		}
 	}
-	switch c.Clevel {
-	case 0:
-	default:
-		if !raw {
-			data = compress.Decompress(c.Calgo, data)
+	if meta := c.loadMeta(file); meta != nil { // legacy pastes come without metadata
+		if p.meta, err = c.openMeta(k[0], secret, meta); err != nil {
+			p.Close()
+			return nil, errors.New("[store] [meta] [" + err.Error() + "]")
+		}
+	}
+	p.SectionReader = io.NewSectionReader(r, 0, size)
+	return p, nil
+}
+
+// loadMeta returns the stored paste metadata [nil: none]
+func (c *Config) loadMeta(file string) []byte {
+	if c.PermSTORE {
+		data, err := c.i.fs.ReadFile(file + _meta)
+		if err != nil {
+			return nil
 		}
+		return data
+	}
+	c.i.storeMUTEX.RLock()
+	defer c.i.storeMUTEX.RUnlock()
+	return c.i.store[file+_meta]
+}
+
+// pasteFile maps the url key to the store backend key
+func pasteFile(key string) (string, []string, error) {
+	k := strings.Split(key, "@")
+	if len(k) < 2 || len(k[1]) < 16 || strings.HasSuffix(key, _meta) {
+		return "", nil, errors.New("[store] [url] [decode] [err]")
+	}
+	switch len(k) {
+	case 2:
+		return k[0] + "@" + k[1][:16], k, nil
+	case 3:
+		return k[0] + "@" + k[1][:16] + "@" + k[2], k, nil
 	}
-	return string(data), nil
+	return "", nil, errors.New("[store] [url] [decode] [err]")
+}
+
+// deletePaste removes the paste, the full url key [incl. decryption key] is the capability
+func (c *Config) deletePaste(key string) error {
+	p, err := c.openPaste(key)
+	if err != nil {
+		return err
+	}
+	p.Close()
+	c.i.etags.Delete(p.file)
+	if c.PermSTORE {
+		c.i.fs.Remove(p.file + _meta)
+		return c.i.fs.Remove(p.file)
+	}
+	c.i.storeMUTEX.Lock()
+	delete(c.i.store, p.file)
+	delete(c.i.store, p.file+_meta)
+	c.i.storeMUTEX.Unlock()
+	return nil
+}
+
+// readPaste ...
+func (c *Config) readPaste(key string, raw bool) ([]byte, error) {
+	data, _, err := c.readPasteMeta(key, raw)
+	return data, err
+}
+
+// readPasteMeta returns paste content and metadata
+func (c *Config) readPasteMeta(key string, raw bool) ([]byte, pasteMeta, error) {
+	p, err := c.openPaste(key)
+	if err != nil {
+		return nil, pasteMeta{}, err
+	}
+	defer p.Close()
+	data, err := p.read(raw)
+	return data, p.meta, err
+}
+
+// read reads the whole [raw|decompressed] paste
+func (p *pasteReader) read(raw bool) ([]byte, error) {
+	r, err := p.content(raw)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
 }
 
 //
@@ -164,26 +427,47 @@ func isExpired(key string) bool {
 	return false
 }
 
-// storeAutoGC
-func storeAutoGC() {
-	time.Sleep(12 * time.Second)
+// storeDir lists the fs store directory
+func (c *Config) storeDir() ([]os.DirEntry, error) {
+	f, err := c.i.fs.Open(".")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.ReadDir(-1)
+}
+
+// storeAutoGC [disable: DisableGC]
+func (c *Config) storeAutoGC(ctx context.Context) {
+	if c.DisableGC {
+		return
+	}
+	interval := c.AutoGCInt
+	if interval <= 0 {
+		interval = _storeGC
+	}
+	if !sleep(ctx, 12*time.Second) {
+		return
+	}
 	for {
 		switch c.PermSTORE {
 		case true:
-			gcFS()
+			c.gcFS()
 		case false:
-			gcMAP()
+			c.gcMAP()
 		default:
 			panic("autogc permstore type")
 
 		}
 		runtime.GC()
-		time.Sleep(20 * time.Minute)
+		if !sleep(ctx, interval) {
+			return
+		}
 	}
 }
 
 // gcMAAP ...
-func gcMAP() {
+func (c *Config) gcMAP() {
 	l := len(c.i.store)
 	if l == 0 && c.i.storeZERO {
 		return
@@ -199,6 +483,7 @@ func gcMAP() {
 		for k := range c.i.store {
 			if isExpired(k) {
 				delete(c.i.store, k)
+				c.i.etags.Delete(k)
 				r++
 				logsec.LogInfo <- "[gc] [removed] [" + k + "]"
 			}
@@ -209,9 +494,9 @@ func gcMAP() {
 }
 
 // gcFS ...
-func gcFS() {
+func (c *Config) gcFS() {
 	c.i.storeZERO = false
-	d, err := os.ReadDir(".")
+	d, err := c.storeDir()
 	if err != nil {
 		logsec.LogErr <- "[gc] [fs] [dir] " + err.Error()
 		return
@@ -223,27 +508,28 @@ func gcFS() {
 	if l == 0 {
 		c.i.storeZERO = true
 	}
-	logsec.LogInfo <- ("[gc] [" + c.Chroot.DIR + "] [total:" + itoa(l) + "]")
+	logsec.LogInfo <- ("[gc] [" + c.i.fs.Name() + "] [total:" + itoa(l) + "]")
 	if l > 0 {
 		c.i.storeZERO = false
 		r := 0
 		for _, key := range d {
 			k := key.Name()
 			if isExpired(k) {
-				err := os.Remove(k)
+				err := c.i.fs.Remove(k)
 				if err != nil {
 					logsec.LogErr <- err.Error()
 				} else {
+					c.i.etags.Delete(k)
 					r++
 					logsec.LogInfo <- "[gc] [removed] [" + k + "]"
 				}
 			}
 		}
-		d, err := os.ReadDir(".")
+		d, err := c.storeDir()
 		if err != nil {
 			logsec.LogErr <- "[gc] [fs] [dir] " + err.Error()
 			return
 		}
-		logsec.LogInfo <- "[gc] [end] [" + c.Chroot.DIR + "] [total:" + itoa(len(d)) + "] [removed:" + itoa(r) + "]"
+		logsec.LogInfo <- "[gc] [end] [" + c.i.fs.Name() + "] [total:" + itoa(len(d)) + "] [removed:" + itoa(r) + "]"
 	}
 }
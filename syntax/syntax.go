@@ -202,23 +202,32 @@ func Print(s *scanner.Scanner, w io.Writer, p Printer) error {
 
 // AsHTML ...
 func AsHTML(src []byte, options ...Option) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := WriteHTML(&buf, bytes.NewReader(src), options...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteHTML streams the highlighted src as html into w
+func WriteHTML(w io.Writer, src io.Reader, options ...Option) error {
 	opt := DefaultHTMLConfig
 	for _, f := range options {
 		f(&opt)
 	}
 
-	var buf bytes.Buffer
 	if opt.AsOrderedList {
-		buf.Write([]byte("\t\t\t<ol><li>"))
+		if _, err := w.Write([]byte("\t\t\t<ol><li>")); err != nil {
+			return err
+		}
 	}
-	err := Print(NewScanner(src), &buf, HTMLPrinter(opt))
+	err := Print(NewScannerReader(src), w, HTMLPrinter(opt))
 	if opt.AsOrderedList {
-		buf.Write([]byte("</li>\n\t\t\t</ol>"))
-	}
-	if err != nil {
-		return nil, err
+		if _, errw := w.Write([]byte("</li>\n\t\t\t</ol>")); err == nil {
+			err = errw
+		}
 	}
-	return buf.Bytes(), nil
+	return err
 }
 
 // NewScanner ...
@@ -0,0 +1,67 @@
+package npad
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+)
+
+//
+// TLS PROFILES
+//
+
+// TLSProfile server tls policy, all profiles are tls 1.3 only [go: tls 1.3 cipher suites are not configurable]
+type TLSProfile int
+
+// tls profiles
+const (
+	TLSStrict   TLSProfile = iota // key exchange x25519 only [default]
+	TLSModern                     // key exchange x25519|p256|p384 [wider client support]
+	TLSHybridPQ                   // post-quantum hybrid key exchange x25519mlkem768 preferred, x25519 fallback
+)
+
+// String ...
+func (p TLSProfile) String() string {
+	switch p {
+	case TLSStrict:
+		return "strict"
+	case TLSModern:
+		return "modern"
+	case TLSHybridPQ:
+		return "pq-hybrid"
+	}
+	return "invalid:" + itoa(int(p))
+}
+
+// curves key exchange groups in server preference order
+func (p TLSProfile) curves() ([]tls.CurveID, error) {
+	switch p {
+	case TLSStrict:
+		return []tls.CurveID{tls.X25519}, nil
+	case TLSModern:
+		return []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384}, nil
+	case TLSHybridPQ:
+		return []tls.CurveID{tls.X25519MLKEM768, tls.X25519}, nil
+	}
+	return nil, errors.New("unknown tls profile [" + p.String() + "]")
+}
+
+// nextProtos alpn protocols [http/2 optional]
+func (c *Config) nextProtos() []string {
+	if c.HTTP2 {
+		return []string{"h2", "http/1.1"}
+	}
+	return []string{"http/1.1"}
+}
+
+// getDiagKEX negotiated key exchange group and server tls profile
+func (c *Config) getDiagKEX(q *http.Request) string {
+	if q.TLS == nil {
+		return _empty
+	}
+	kex := q.TLS.CurveID.String()
+	if q.TLS.CurveID == tls.X25519MLKEM768 {
+		kex += " [post-quantum hybrid]"
+	}
+	return pad("Key Exchange Group") + " : " + kex + _linefeed + pad("Server TLS Profile") + " : " + c.TLS.String() + _linefeed
+}
@@ -12,9 +12,9 @@ const (
 
 	head     = "<html>\n<head>\n\t<style>\n\tbody{line-height:1.1;font-size:1.5em;text-align:center;background:#3367d6;color:#FFF}\n\tbutton{background:#3367d5;color:#FFF;border:none;border-radius:2px}\n\tbutton:hover{color:#3367d5;background:#FFF;}\n\t.i{stroke:currentColor;stroke-width:2px;stroke-linecap:round;stroke-linejoin:round;fill:none;width:1em;height:1em}\n\tsvg, svg symbol{overflow:visible;}"
 	headb    = "<html>\n<head>\n\t<style>\n\tbody{line-height:1.1;font-size:1.5em;text-align:center;background:#000;color:#FFF}\n\tbutton{background:#3367d5;color:#FFF;border:none;border-radius:2px}\n\tbutton:hover{color:#3367d5;background:#FFF;}\n\t.i{stroke:currentColor;stroke-width:2px;stroke-linecap:round;stroke-linejoin:round;fill:none;width:1em;height:1em}\n\tsvg, svg symbol{overflow:visible;}"
-	h1       = head + "\n\ttextarea{background:#3367d6;color:#FFF;}" + endStyle + icon
-	h2       = head + "\n\tpre{font-size:0.6em;text-align:left}" + endStyle + icon
-	h2b      = headb + "\n\tpre{font-size:0.6em;text-align:left;line-height:1.1;}" + endStyle + icon
+	h1       = head + "\n\ttextarea{background:#3367d6;color:#FFF;}" + endStyle
+	h2       = head + "\n\tpre{font-size:0.6em;text-align:left}" + endStyle
+	h2b      = headb + "\n\tpre{font-size:0.6em;text-align:left;line-height:1.1;}" + endStyle
 	endHead  = "\n</head>\n"
 	body     = "\n<body>\n"
 	pre      = "\n<pre>\n"
@@ -22,14 +22,18 @@ const (
 	endPre   = "\n</pre>\n"
 	endStyle = "\n</style>\n"
 	endBody  = "\n</body>\n</html>\n"
-	form     = "<br>" + formdef + formbox + "\n\t</form>"
-	formdef  = "\n\t<form action=\"/\" method=\"post\">"
-	formbox  = input1 + "<br><br>" + input2 + "<br>" + input3 + "<br><br>" + expire + "<br>" + input4
+	formHead = "<br>" + formdef + "\n\t<input type=\"hidden\" name=\"" + _csrfField + "\" value=\""
+	formTail = "\">" + formbox + "\n\t</form>"
+	formdef  = "\n\t<form method=\"post\" enctype=\"multipart/form-data\">"
+	formbox  = input1 + "<br><br>" + input5 + "<br><br>" + input2 + "<br>" + input3 + "<br>" + input6 + input7 + "<br><br>" + expire + "<br>" + input4
 	gorepo   = "paepcke.de/npad"
 	input1   = "\n\t<textarea autofocus rows=\"46\" cols=\"80\" name=\"pa\"></textarea>"
 	input2   = "<button style=\"padding:3px 2px\">[optional name]" + bue
 	input3   = "<textarea rows=\"1\" cols=\"32\" name=\"na\" maxlength=\"32\"></textarea>"
 	input4   = "\n\t<button type=\"submit\">" + up + bue
+	input5   = "\n\t<input type=\"file\" name=\"fi\" style=\"font-size:0.7em;\">"
+	input6   = "<button style=\"padding:3px 2px\">[optional private: me|cn:|email:|sha256:]" + bue
+	input7   = "<textarea rows=\"1\" cols=\"32\" name=\"ac\"></textarea>"
 	expire   = "\n\t<SELECT NAME=\"ex\" style=\"font-size:0.7em;\" >" + exp0 + exp1 + exp2 + exp3 + "</style></SELECT><br>"
 	exp0     = "<OPTION VALUE=\"0\">[Expire:20min][Max:10MB]"
 	exp1     = "<OPTION VALUE=\"1\"SELECTED>[Expire:8hours][Max:8MB]"
@@ -39,7 +43,8 @@ const (
 	bue      = "</button>"
 	href     = "\n\t<a href=\""
 	disabled = "[function disabled or local database not ready yet]"
-	icon     = "\n\t<link rel=\"icon\" type=\"image/svg+xml\" href=\"/i.svg\"/>"
+	icon     = "\n\t<link rel=\"icon\" type=\"image/svg+xml\" href=\""
+	iconEnd  = "\"/>"
 	li1      = "\t<li><span class=\"kwd\">"
 	li2      = "</span>:  <span class=\"str\">"
 	li0      = "</span>:  <span class=\"fun\">"
@@ -77,6 +77,43 @@ func GetStringSVG(in string) string {
 	return string(out)
 }
 
+// GetStringText returns a terminal [utf8 half block] qr code from string
+func GetStringText(in string) string {
+	if len(in) > 3500 {
+		return _empty
+	}
+	qrCode, err := Encode(in, M, Auto)
+	if err != nil {
+		return _empty
+	}
+	const quiet = 2
+	w := qrCode.Bounds().Dx()
+	light := func(x, y int) bool {
+		if x < 0 || y < 0 || x >= w || y >= w {
+			return true
+		}
+		return qrCode.At(x, y) != color.Black
+	}
+	var s strings.Builder
+	for y := -quiet; y < w+quiet; y += 2 {
+		for x := -quiet; x < w+quiet; x++ {
+			top, bottom := light(x, y), light(x, y+1)
+			switch {
+			case top && bottom:
+				s.WriteString("\u2588")
+			case top:
+				s.WriteString("\u2580")
+			case bottom:
+				s.WriteString("\u2584")
+			default:
+				s.WriteByte(' ')
+			}
+		}
+		s.WriteByte('\n')
+	}
+	return s.String()
+}
+
 //
 // INTERNAL BACKEND
 //
@@ -0,0 +1,300 @@
+package npad
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"paepcke.de/npad/encrypt"
+)
+
+//
+// CONFIG VALIDATION
+//
+
+// ConfigError invalid config field
+type ConfigError struct {
+	Field string // config field [Calgo|Listeners[1].Addr|Chroot.DIR|...]
+	Err   error
+}
+
+// Error ...
+func (e *ConfigError) Error() string {
+	return "[config] [" + e.Field + "] [" + e.Err.Error() + "]"
+}
+
+// Unwrap ...
+func (e *ConfigError) Unwrap() error { return e.Err }
+
+// Validate checks every config field [files, algos, levels, addresses, intervals] before anything starts,
+// returns all findings joined [nil: valid], New and Start refuse to run on any finding
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(field, msg string) {
+		errs = append(errs, &ConfigError{Field: field, Err: errors.New(msg)})
+	}
+	check := func(field string, err error) {
+		if err != nil {
+			errs = append(errs, &ConfigError{Field: field, Err: err})
+		}
+	}
+
+	// app, log
+	if c.App == _empty {
+		fail("App", "required")
+	}
+	if c.Log == nil {
+		fail("Log", "required")
+	}
+
+	// network
+	tlsMode := c.CAcert != _empty && c.CAkey != _empty
+	if c.ListenAddr != _empty {
+		check("ListenAddr", validAddr(c.ListenAddr))
+	}
+	_, err := parsePublicURL(c.PublicURL)
+	check("PublicURL", err)
+	if strings.ContainsAny(c.BasePath, "?#") {
+		fail("BasePath", "invalid path prefix ["+c.BasePath+"]")
+	}
+	for i, l := range c.Listeners {
+		field := "Listeners[" + itoa(i) + "]"
+		switch l.Transport {
+		case TransportTLS:
+			if !tlsMode {
+				fail(field+".Transport", "tls listener needs a server certificate [CAcert|CAkey]")
+			}
+			check(field+".Addr", validAddr(l.Addr))
+		case TransportLoopback:
+			if err := validAddr(l.Addr); err != nil {
+				check(field+".Addr", err)
+				break
+			}
+			host, _, _ := net.SplitHostPort(l.Addr)
+			if a, err := netip.ParseAddr(host); err == nil && !a.IsLoopback() {
+				fail(field+".Addr", "not a loopback address ["+l.Addr+"]")
+			}
+		case TransportUnix:
+			if l.Addr == _empty {
+				fail(field+".Addr", "socket path required")
+			}
+			if l.Mode&^fs.ModePerm != 0 {
+				fail(field+".Mode", "permission bits only ["+l.Mode.String()+"]")
+			}
+			if l.GID < 0 {
+				fail(field+".GID", "negative group id")
+			}
+		default:
+			fail(field+".Transport", "unknown transport ["+l.Transport.String()+"]")
+		}
+	}
+	for _, s := range c.TrustedProxies {
+		if s != _proxyUnix {
+			_, err := parseProxy(s)
+			check("TrustedProxies", err)
+		}
+	}
+	if c.ListenProxy && c.ListenAddr == _empty {
+		fail("ListenProxy", "needs ListenAddr")
+	}
+
+	// nc listener
+	if c.NcAddr != _empty {
+		check("NcAddr", validAddr(c.NcAddr))
+		if c.NcTLS && !tlsMode {
+			fail("NcTLS", "needs a server certificate [CAcert|CAkey]")
+		}
+	}
+	if c.NcExpire < 0 || c.NcExpire >= len(tierMax) {
+		fail("NcExpire", "unknown retention tier ["+itoa(c.NcExpire)+"] [0:20min|1:8h|2:14days|3:never]")
+	}
+	if c.NcMax < 0 {
+		fail("NcMax", "negative size limit")
+	}
+	if c.NcTimeout < 0 {
+		fail("NcTimeout", "negative timeout")
+	}
+
+	// tls certificates
+	switch {
+	case (c.CAcert == _empty) != (c.CAkey == _empty):
+		fail("CAcert|CAkey", "server certificate and key are only valid together")
+	case tlsMode:
+		if _, err := tls.LoadX509KeyPair(c.CAcert, c.CAkey); err != nil {
+			fail("CAcert|CAkey", "unable to [read|decode] ["+c.CAcert+"|"+c.CAkey+"] ["+err.Error()+"]")
+		}
+	}
+	_, err = c.TLS.curves()
+	check("TLS", err)
+	if c.HTTP2 && !tlsMode {
+		fail("HTTP2", "needs a server certificate [CAcert|CAkey]")
+	}
+	if c.CertReload < 0 {
+		fail("CertReload", "negative interval")
+	}
+	var cas []*x509.Certificate
+	if c.CAclient != _empty {
+		if !tlsMode {
+			fail("CAclient", "needs a server certificate [CAcert|CAkey]")
+		}
+		data, err := os.ReadFile(c.CAclient)
+		switch {
+		case err != nil:
+			check("CAclient", err)
+		default:
+			if cas = parseCerts(data); len(cas) == 0 {
+				fail("CAclient", "no client ca certificate ["+c.CAclient+"]")
+			}
+		}
+	}
+	if c.CAPrivateOnly && c.CAclient == _empty {
+		fail("CAPrivateOnly", "needs a client ca [CAclient]")
+	}
+	if c.CAPrivateOnly && c.NcAddr != _empty && !c.NcTLS {
+		fail("CAPrivateOnly", "plaintext nc listener accepts uploads without client certificate [NcTLS]")
+	}
+	for i, l := range c.Listeners {
+		if c.CAPrivateOnly && l.Transport != TransportTLS {
+			fail("CAPrivateOnly", "plaintext listener without client certificate [Listeners["+itoa(i)+"]] ["+l.Transport.String()+"]")
+		}
+	}
+
+	// client certificate revocation
+	if (len(c.CRLfiles) > 0 || c.OCSPdir != _empty) && c.CAclient == _empty {
+		fail("CRLfiles|OCSPdir", "revocation checks need a client ca [CAclient]")
+	}
+	for _, name := range c.CRLfiles {
+		data, err := os.ReadFile(name)
+		if err == nil && len(cas) > 0 {
+			_, _, err = parseCRL(data, cas)
+		}
+		if err != nil {
+			fail("CRLfiles", "["+name+"] ["+err.Error()+"]")
+		}
+	}
+	if c.CRLreload < 0 {
+		fail("CRLreload", "negative interval")
+	}
+	if c.OCSPdir != _empty {
+		check("OCSPdir", validDir(c.OCSPdir))
+	}
+
+	// roles
+	if c.Roles != nil {
+		if len(c.Roles.Rules) > 0 && c.CAclient == _empty {
+			fail("Roles", "certificate rules need a client ca [CAclient]")
+		}
+		for i, rule := range c.Roles.Rules {
+			if rule.Fingerprint == _empty {
+				continue
+			}
+			if b, err := hex.DecodeString(strings.ReplaceAll(rule.Fingerprint, ":", _empty)); err != nil || len(b) != 32 {
+				fail("Roles.Rules["+itoa(i)+"].Fingerprint", "want sha256 hex [colon separated ok] ["+rule.Fingerprint+"]")
+			}
+		}
+	}
+
+	// data store backend
+	switch c.Calgo {
+	case _empty:
+		if c.Clevel != 0 {
+			fail("Clevel", "compression level without compression algo [Calgo]")
+		}
+	case "ZSTD":
+		if c.Clevel < 0 || c.Clevel > 19 {
+			fail("Clevel", "invalid compression level [ZSTD:"+itoa(c.Clevel)+"] [1-19|disable: 0]")
+		}
+	case "GZIP", "DEFLATE":
+		if c.Clevel < 0 || c.Clevel > 9 {
+			fail("Clevel", "invalid compression level ["+c.Calgo+":"+itoa(c.Clevel)+"] [1-9|disable: 0]")
+		}
+	default:
+		fail("Calgo", "unsupported compression algo ["+c.Calgo+"] [ZSTD|GZIP|DEFLATE]")
+	}
+	if c.Ealgo != _empty && !encrypt.Supported(c.Ealgo) {
+		fail("Ealgo", "unsupported encryption algo ["+c.Ealgo+"] [AESGCM|GCMSIV]")
+	}
+	if c.AutoGCInt < 0 {
+		fail("AutoGCInt", "negative interval")
+	}
+	if c.DisableGC && !c.PermSTORE {
+		fail("DisableGC", "ram store without gc grows without bound [PermSTORE]")
+	}
+
+	// transport, headers, rate limits
+	for i, l := range c.Tpolicy {
+		if l.Size < 0 || l.ZSTD < 0 || l.ZSTD > 19 || l.GZIP < 0 || l.GZIP > 9 {
+			fail("Tpolicy["+itoa(i)+"]", "invalid size class [size >= 0|ZSTD:0-19|GZIP:0-9]")
+		}
+	}
+	if h := c.Headers; h != nil {
+		switch h.FrameOptions {
+		case _empty, "DENY", "SAMEORIGIN":
+		default:
+			fail("Headers.FrameOptions", "unknown value ["+h.FrameOptions+"] [DENY|SAMEORIGIN]")
+		}
+		if h.HSTS < 0 {
+			fail("Headers.HSTS", "negative max-age")
+		}
+	}
+	if r := c.Rlimit; r != nil {
+		if r.Create.PerSec < 0 || r.Create.Burst < 0 || r.Read.PerSec < 0 || r.Read.Burst < 0 || r.Diag.PerSec < 0 || r.Diag.Burst < 0 {
+			fail("Rlimit", "negative rate")
+		}
+		if r.Idle < 0 {
+			fail("Rlimit.Idle", "negative interval")
+		}
+	}
+
+	// chroot
+	if ch := c.Chroot; ch != nil {
+		if ch.DIR != _empty {
+			if !filepath.IsAbs(ch.DIR) {
+				fail("Chroot.DIR", "absolute path required ["+ch.DIR+"]")
+			} else {
+				check("Chroot.DIR", validDir(ch.DIR))
+			}
+		}
+		if ch.UID < 0 {
+			fail("Chroot.UID", "negative user id")
+		}
+		if ch.GID < 0 {
+			fail("Chroot.GID", "negative group id")
+		}
+		if (ch.UID > 0 || ch.GID > 0) && ch.DIR == _empty {
+			fail("Chroot.DIR", "priv drop needs a chroot directory")
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validAddr name:port [numeric|service port]
+func validAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if _, err = net.LookupPort("tcp", port); err != nil {
+		return errors.New("invalid port [" + addr + "]")
+	}
+	return nil
+}
+
+// validDir ...
+func validDir(dir string) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return errors.New("not a directory [" + dir + "]")
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package npad
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"paepcke.de/logsec"
+	"paepcke.de/npad/compress"
+)
+
+// configFields failing config fields of a Validate result
+func configFields(err error) []string {
+	var fields []string
+	j, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return nil
+	}
+	for _, e := range j.Unwrap() {
+		var ce *ConfigError
+		if errors.As(e, &ce) {
+			fields = append(fields, ce.Field)
+		}
+	}
+	return fields
+}
+
+func TestConfigValidate(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, []byte("no pem"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fp := strings.Repeat("ab", 32)
+	colon := strings.TrimSuffix(strings.Repeat("AB:", 32), ":")
+	tests := []struct {
+		name string
+		mod  func(*Config)
+		want []string // failing fields [nil: valid]
+	}{
+		{"minimal", func(c *Config) {}, nil},
+		{"listeners", func(c *Config) {
+			c.ListenAddr, c.ListenProxy, c.TrustedProxies = "127.0.0.1:http", true, []string{"10.0.0.0/8", "unix", "::1"}
+			c.Listeners = []Listener{{Addr: "[::1]:8443", Transport: TransportLoopback}, {Addr: "/run/npad.sock", Transport: TransportUnix, Mode: 0o660}}
+		}, nil},
+		{"store", func(c *Config) {
+			c.Calgo, c.Clevel, c.Ealgo, c.AutoGC, c.AutoGCInt = "GZIP", 9, "GCMSIV", true, time.Minute
+		}, nil},
+		{"missing app and log", func(c *Config) { c.App, c.Log = _empty, nil }, []string{"App", "Log"}},
+		{"bad addresses", func(c *Config) { c.ListenAddr, c.NcAddr = "localhost", "127.0.0.1:nope" }, []string{"ListenAddr", "NcAddr"}},
+		{"proxy without listener", func(c *Config) { c.ListenProxy = true }, []string{"ListenProxy"}},
+		{"bad trusted proxy", func(c *Config) { c.TrustedProxies = []string{"proxy.example.org"} }, []string{"TrustedProxies"}},
+		{"base path", func(c *Config) { c.BasePath = "/npad?x" }, []string{"BasePath"}},
+		{"tls listener without cert", func(c *Config) { c.Listeners = []Listener{{Addr: ":443", Transport: TransportTLS}} }, []string{"Listeners[0].Transport"}},
+		{"loopback listener", func(c *Config) {
+			c.Listeners = []Listener{{Addr: "192.0.2.1:8080", Transport: TransportLoopback}}
+		}, []string{"Listeners[0].Addr"}},
+		{"unix listener", func(c *Config) {
+			c.Listeners = []Listener{{Transport: TransportUnix, Mode: os.ModeSetuid | 0o660, GID: -1}}
+		}, []string{"Listeners[0].Addr", "Listeners[0].Mode", "Listeners[0].GID"}},
+		{"nc", func(c *Config) { c.NcExpire, c.NcMax, c.NcTimeout = 4, -1, -1 }, []string{"NcExpire", "NcMax", "NcTimeout"}},
+		{"cert without key", func(c *Config) { c.CAcert = file }, []string{"CAcert|CAkey"}},
+		{"unreadable cert", func(c *Config) { c.CAcert, c.CAkey = file, file }, []string{"CAcert|CAkey"}},
+		{"tls features without cert", func(c *Config) { c.HTTP2, c.NcAddr, c.NcTLS = true, "127.0.0.1:9999", true }, []string{"NcTLS", "HTTP2"}},
+		{"tls profile", func(c *Config) { c.TLS = TLSProfile(99) }, []string{"TLS"}},
+		{"client ca", func(c *Config) { c.CAclient, c.CAPrivateOnly = file, true }, []string{"CAclient", "CAclient"}},
+		{"revocation without client ca", func(c *Config) { c.OCSPdir, c.CRLreload = dir, -time.Second }, []string{"CRLfiles|OCSPdir", "CRLreload"}},
+		{"private only without client ca", func(c *Config) { c.CAPrivateOnly = true }, []string{"CAPrivateOnly"}},
+		{"private only plaintext listeners", func(c *Config) {
+			c.CAPrivateOnly, c.NcAddr = true, "127.0.0.1:9999"
+			c.Listeners = []Listener{{Addr: "127.0.0.1:8080", Transport: TransportLoopback}}
+		}, []string{"CAPrivateOnly", "CAPrivateOnly", "CAPrivateOnly"}},
+		{"role fingerprints", func(c *Config) {
+			c.Roles = &RolePolicy{Rules: []RoleRule{{Fingerprint: fp}, {Fingerprint: colon}, {Fingerprint: "ab:cd"}, {Fingerprint: fp + "zz"}}}
+		}, []string{"Roles", "Roles.Rules[2].Fingerprint", "Roles.Rules[3].Fingerprint"}},
+		{"compression", func(c *Config) { c.Calgo, c.Clevel = "ZSTD", 20 }, []string{"Clevel"}},
+		{"level without algo", func(c *Config) { c.Clevel = 1 }, []string{"Clevel"}},
+		{"unknown algos", func(c *Config) { c.Calgo, c.Ealgo = "LZ4", "ROT13" }, []string{"Calgo", "Ealgo"}},
+		{"ram store without gc", func(c *Config) { c.DisableGC = true }, []string{"DisableGC"}},
+		{"permanent store without gc", func(c *Config) { c.DisableGC, c.PermSTORE = true, true }, nil},
+		{"intervals", func(c *Config) { c.AutoGCInt, c.CertReload = -1, -1 }, []string{"CertReload", "AutoGCInt"}},
+		{"policies", func(c *Config) {
+			c.Tpolicy = compress.Policy{{Size: -1}}
+			c.Headers = &HeaderPolicy{FrameOptions: "ALLOW", HSTS: -1}
+			c.Rlimit = &RateLimits{Read: Rate{PerSec: -1}, Idle: -1}
+		}, []string{"Tpolicy[0]", "Headers.FrameOptions", "Headers.HSTS", "Rlimit", "Rlimit.Idle"}},
+		{"chroot", func(c *Config) { c.Chroot = &logsec.ChrootD{DIR: "relative", UID: -1, GID: -1} }, []string{"Chroot.DIR", "Chroot.UID", "Chroot.GID"}},
+		{"chroot missing dir", func(c *Config) { c.Chroot = &logsec.ChrootD{DIR: filepath.Join(dir, "missing")} }, []string{"Chroot.DIR"}},
+		{"priv drop without chroot", func(c *Config) { c.Chroot = &logsec.ChrootD{UID: 65534} }, []string{"Chroot.DIR"}},
+		{"chroot dir", func(c *Config) { c.Chroot = &logsec.ChrootD{DIR: dir, UID: 65534, GID: 65534} }, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &Config{App: "npad", Log: &logsec.LogD{LogMode: "MUTE"}}
+			tc.mod(c)
+			err := c.Validate()
+			if got := configFields(err); !slices.Equal(got, tc.want) {
+				t.Fatalf("fields %q, want %q [%v]", got, tc.want, err)
+			}
+		})
+	}
+}